@@ -17,6 +17,7 @@ import (
 	"github.com/DoNewsCode/core/container"
 	"github.com/DoNewsCode/core/contract"
 	"github.com/DoNewsCode/core/di"
+	"github.com/DoNewsCode/core/events"
 	"github.com/DoNewsCode/core/logging"
 	"github.com/go-kit/kit/log"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -198,9 +199,29 @@ func New(opts ...CoreOption) *C {
 		Dispatcher:     dispatcher,
 		di:             diContainer,
 	}
+	if sync, ok := dispatcher.(*events.SyncDispatcher); ok && sync.Logger == nil {
+		sync.Logger = logger
+	}
+	if async, ok := dispatcher.(*events.AsyncDispatcher); ok {
+		if async.ErrorHandler == nil {
+			async.ErrorHandler = func(ctx context.Context, event contract.Event, err error) {
+				c.LevelLogger.Errf("listener of event %s failed: %s", event.Type(), err)
+			}
+		}
+	}
 	return &c
 }
 
+// Shutdown closes the modules registered in the core. If the dispatcher is an
+// events.AsyncDispatcher, the buffered events are handled first, as their
+// listeners may still use the modules.
+func (c *C) Shutdown() {
+	if async, ok := c.Dispatcher.(*events.AsyncDispatcher); ok {
+		async.Close()
+	}
+	c.Container.Shutdown()
+}
+
 // Default creates a core.C under its default state. Core dependencies are
 // already provided, and the config module and serve module are bundled.
 func Default(opts ...CoreOption) *C {
@@ -176,3 +176,21 @@ func put(cfg clientv3.Config, key, val string) error {
 	}
 	return nil
 }
+
+func TestC_Shutdown(t *testing.T) {
+	var handled, closed int32
+	c := New(WithInline("events.dispatcher", "async"))
+	c.Dispatcher.Subscribe(events.Listen([]contract.Event{events.Of(OnHTTPServerShutdown{})}, func(ctx context.Context, event contract.Event) error {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&handled, 1)
+		return nil
+	}))
+	c.AddModule(func() {
+		// The buffered events are handled before the modules are closed.
+		atomic.StoreInt32(&closed, atomic.LoadInt32(&handled))
+	})
+
+	assert.NoError(t, c.Dispatch(context.Background(), events.Of(OnHTTPServerShutdown{})))
+	c.Shutdown()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&closed))
+}
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"fmt"
 	stdlog "log"
+	"runtime"
 
 	"github.com/DoNewsCode/core/config"
 	"github.com/DoNewsCode/core/contract"
@@ -109,8 +111,43 @@ func ProvideDi(conf contract.ConfigAccessor) DiContainer {
 }
 
 // ProvideEventDispatcher is the default EventDispatcherProvider for package Core.
+// By default, the events are dispatched synchronously. Set "events.dispatcher"
+// to "async" to use the events.AsyncDispatcher instead. The synchronous
+// dispatcher handles the errors of listeners by "events.errorStrategy", which
+// is one of "stop", "aggregate" or "log". Other values are configuration
+// errors, and cause a panic.
 func ProvideEventDispatcher(conf contract.ConfigAccessor) contract.Dispatcher {
-	return &events.SyncDispatcher{}
+	var eventsConf struct {
+		Dispatcher    string `yaml:"dispatcher" json:"dispatcher"`
+		ErrorStrategy string `yaml:"errorStrategy" json:"errorStrategy"`
+		Workers       int    `yaml:"workers" json:"workers"`
+		BufferSize    int    `yaml:"bufferSize" json:"bufferSize"`
+		DropWhenFull  bool   `yaml:"dropWhenFull" json:"dropWhenFull"`
+	}
+	_ = conf.Unmarshal("events", &eventsConf)
+	var strategy events.ErrorStrategy
+	switch eventsConf.ErrorStrategy {
+	case "", "stop":
+		strategy = events.StopOnError
+	case "aggregate":
+		strategy = events.AggregateErrors
+	case "log":
+		strategy = events.LogErrors
+	default:
+		panic(fmt.Errorf("unknown events error strategy %s", eventsConf.ErrorStrategy))
+	}
+	switch eventsConf.Dispatcher {
+	case "", "sync":
+		return &events.SyncDispatcher{ErrorStrategy: strategy}
+	case "async":
+		return &events.AsyncDispatcher{
+			Workers:      eventsConf.Workers,
+			BufferSize:   eventsConf.BufferSize,
+			DropWhenFull: eventsConf.DropWhenFull,
+		}
+	default:
+		panic(fmt.Errorf("unknown events dispatcher %s", eventsConf.Dispatcher))
+	}
 }
 
 // provideDefaultConfig exports config for "name", "version", "env", "http", "grpc".
@@ -173,5 +210,18 @@ func provideDefaultConfig() []config.ExportedConfig {
 			},
 			Comment: "The global logging level and format",
 		},
+		{
+			Owner: "core",
+			Data: map[string]interface{}{
+				"events": map[string]interface{}{
+					"dispatcher":    "sync",
+					"errorStrategy": "stop",
+					"workers":       runtime.NumCPU(),
+					"bufferSize":    1024,
+					"dropWhenFull":  false,
+				},
+			},
+			Comment: "The event dispatcher, either sync or async. The errorStrategy (stop, aggregate or log) only applies to the sync dispatcher, and the others only to the async one",
+		},
 	}
 }
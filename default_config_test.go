@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/DoNewsCode/core/events"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,3 +13,22 @@ func TestDefaultConfig(t *testing.T) {
 	_, err := json.Marshal(conf)
 	assert.NoError(t, err)
 }
+
+func TestProvideEventDispatcher(t *testing.T) {
+	c := New()
+	assert.IsType(t, &events.SyncDispatcher{}, c.Dispatcher)
+	assert.Equal(t, events.StopOnError, c.Dispatcher.(*events.SyncDispatcher).ErrorStrategy)
+	assert.NotNil(t, c.Dispatcher.(*events.SyncDispatcher).Logger)
+
+	c = New(WithInline("events.errorStrategy", "aggregate"))
+	assert.Equal(t, events.AggregateErrors, c.Dispatcher.(*events.SyncDispatcher).ErrorStrategy)
+
+	c = New(WithInline("events.dispatcher", "async"), WithInline("events.workers", 2))
+	assert.IsType(t, &events.AsyncDispatcher{}, c.Dispatcher)
+	assert.Equal(t, 2, c.Dispatcher.(*events.AsyncDispatcher).Workers)
+	assert.NotNil(t, c.Dispatcher.(*events.AsyncDispatcher).ErrorHandler)
+	c.Shutdown()
+
+	assert.Panics(t, func() { New(WithInline("events.dispatcher", "asynchronous")) })
+	assert.Panics(t, func() { New(WithInline("events.errorStrategy", "ignore")) })
+}
@@ -32,10 +32,11 @@ type Step struct {
 
 // Registry holds all transaction sagas in this process. It should be populated during the initialization of the application.
 type Registry struct {
-	logger     log.Logger
-	Store      Store
-	timeout    time.Duration
-	dispatcher contract.Dispatcher
+	logger      log.Logger
+	Store       Store
+	timeout     time.Duration
+	dispatcher  contract.Dispatcher
+	middlewares []events.Middleware
 }
 
 // Option is the functional option for NewRegistry.
@@ -56,17 +57,28 @@ func WithTimeout(duration time.Duration) Option {
 	}
 }
 
+// WithMiddleware is an option that wraps the compensating actions with event
+// middlewares, as they are called through an event dispatcher. For example,
+// events.Recover turns panics in Undo into errors.
+func WithMiddleware(middlewares ...events.Middleware) Option {
+	return func(registry *Registry) {
+		registry.middlewares = append(registry.middlewares, middlewares...)
+	}
+}
+
 // NewRegistry creates a new Registry.
 func NewRegistry(store Store, opts ...Option) *Registry {
 	r := &Registry{
-		dispatcher: &events.SyncDispatcher{},
-		logger:     log.NewNopLogger(),
-		Store:      store,
-		timeout:    10 * time.Minute,
+		logger:  log.NewNopLogger(),
+		Store:   store,
+		timeout: 10 * time.Minute,
 	}
 	for _, f := range opts {
 		f(r)
 	}
+	dispatcher := &events.SyncDispatcher{}
+	dispatcher.Use(r.middlewares...)
+	r.dispatcher = dispatcher
 	return r
 }
 
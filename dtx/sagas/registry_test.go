@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
 	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 )
@@ -94,3 +96,36 @@ func TestRegistry_RecoverSerialized(t *testing.T) {
 	})
 	reg.Recover(context.Background())
 }
+
+func TestRegistry_WithMiddleware(t *testing.T) {
+	store := NewInProcessStore()
+	store.transactions["test"] = []Log{{
+		ID:            "0",
+		CorrelationID: "2",
+		StartedAt:     time.Now().Add(-time.Hour),
+		LogType:       Session,
+	}, {
+		ID:            "1",
+		CorrelationID: "2",
+		StartedAt:     time.Now().Add(-time.Hour),
+		LogType:       Do,
+		StepName:      "foo",
+	}}
+	var called bool
+	reg := NewRegistry(store, WithMiddleware(events.Recover(), func(listener contract.Listener, next events.Handler) events.Handler {
+		return func(ctx context.Context, event contract.Event) error {
+			called = true
+			err := next(ctx, event)
+			assert.IsType(t, events.PanicError{}, err)
+			return err
+		}
+	}))
+	reg.AddStep(&Step{
+		Name: "foo",
+		Undo: func(ctx context.Context, req interface{}) (err error) {
+			panic("boom")
+		},
+	})
+	assert.NotPanics(t, func() { reg.Recover(context.Background()) })
+	assert.True(t, called)
+}
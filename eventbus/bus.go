@@ -0,0 +1,211 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// Transport carries the encoded events between processes. Every message published must be received by all
+// processes, including the publisher.
+type Transport interface {
+	// Publish sends the message to all processes.
+	Publish(ctx context.Context, message []byte) error
+	// Receive calls handle with each message received, until the context is canceled. It returns nil when the
+	// context is canceled.
+	Receive(ctx context.Context, handle func(message []byte)) error
+}
+
+// Codec serializes the event data. All processes on the bus must use the same Codec. queue.Packer implementations
+// are also valid Codecs.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is a Codec that serializes event data as JSON. It is the default Codec.
+type JSONCodec struct{}
+
+// Marshal implements Codec.
+func (J JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec.
+func (J JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// message is the wire format of events on the bus.
+type message struct {
+	Origin string `json:"origin"`
+	Type   string `json:"type"`
+	Data   []byte `json:"data"`
+}
+
+// remoteEvent marks the events received from other processes. Only the received event is marked, so that the events
+// dispatched by its listeners are still shared.
+type remoteEvent struct {
+	contract.Event
+}
+
+// IsRemote reports whether the event is received from another process.
+func IsRemote(event contract.Event) bool {
+	_, ok := event.(remoteEvent)
+	return ok
+}
+
+// Dispatcher is a contract.Dispatcher that shares events with other processes. The types of events to share must be
+// registered by Register, in every process on the bus. Events of registered types are dispatched to the local
+// listeners as usual, and published to the Transport. Events received from the Transport are dispatched to the local
+// listeners of the other processes. Events of other types stay in the process.
+//
+// The remote events are delivered by Run. It must be running in every process that wishes to receive them. Errors of
+// listeners processing remote events are logged.
+type Dispatcher struct {
+	base         contract.Dispatcher
+	transport    Transport
+	codec        Codec
+	logger       log.Logger
+	origin       string
+	rwLock       sync.RWMutex
+	reflectTypes map[string]reflect.Type
+}
+
+// WithBus wraps the base dispatcher, sharing the registered events through the transport.
+func WithBus(base contract.Dispatcher, transport Transport, opts ...func(*Dispatcher)) *Dispatcher {
+	d := &Dispatcher{
+		base:         base,
+		transport:    transport,
+		codec:        JSONCodec{},
+		logger:       log.NewNopLogger(),
+		origin:       xid.New().String(),
+		reflectTypes: make(map[string]reflect.Type),
+	}
+	for _, f := range opts {
+		f(d)
+	}
+	return d
+}
+
+// UseCodec is an option for WithBus that sets the Codec of event data.
+func UseCodec(codec Codec) func(*Dispatcher) {
+	return func(dispatcher *Dispatcher) {
+		dispatcher.codec = codec
+	}
+}
+
+// UseLogger is an option for WithBus that logs the failures of remote events.
+func UseLogger(logger log.Logger) func(*Dispatcher) {
+	return func(dispatcher *Dispatcher) {
+		dispatcher.logger = logger
+	}
+}
+
+// Register marks the types of the given events as shared. Received events are decoded into the types registered.
+//
+//  dispatcher.Register(events.Of(CacheInvalidated{}))
+func (d *Dispatcher) Register(evts ...contract.Event) {
+	d.rwLock.Lock()
+	defer d.rwLock.Unlock()
+	for _, e := range evts {
+		d.reflectTypes[e.Type()] = reflect.TypeOf(e.Data())
+	}
+}
+
+// Dispatch dispatches the event to the local listeners. If the event type is registered, and the event is not
+// received from the bus, it is then published to the other processes, regardless of the local outcome. The local
+// listeners are called even if the transport is down. Errors of publishing are returned along with those of the
+// local listeners.
+func (d *Dispatcher) Dispatch(ctx context.Context, event contract.Event) error {
+	err := d.base.Dispatch(ctx, event)
+	if d.reflectType(event.Type()) == nil || IsRemote(event) {
+		return err
+	}
+	if publishErr := d.publish(ctx, event); publishErr != nil {
+		if err == nil {
+			return publishErr
+		}
+		return multierror.Append(err, publishErr)
+	}
+	return err
+}
+
+// Subscribe subscribes the listener to the base dispatcher. The listener receives both local and remote events.
+func (d *Dispatcher) Subscribe(listener contract.Listener) {
+	d.base.Subscribe(listener)
+}
+
+// Run receives the events from other processes, and dispatches them to the local listeners, until the context is
+// canceled.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	return d.transport.Receive(ctx, func(data []byte) {
+		if err := d.receive(ctx, data); err != nil {
+			_ = level.Warn(d.logger).Log("err", err)
+		}
+	})
+}
+
+// ProvideRunGroup implements container.RunProvider.
+func (d *Dispatcher) ProvideRunGroup(group *run.Group) {
+	ctx, cancel := context.WithCancel(context.Background())
+	group.Add(func() error {
+		return d.Run(ctx)
+	}, func(err error) {
+		cancel()
+	})
+}
+
+func (d *Dispatcher) publish(ctx context.Context, event contract.Event) error {
+	data, err := d.codec.Marshal(event.Data())
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode event %s", event.Type())
+	}
+	msg, err := json.Marshal(message{Origin: d.origin, Type: event.Type(), Data: data})
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode event %s", event.Type())
+	}
+	if err := d.transport.Publish(ctx, msg); err != nil {
+		return errors.Wrapf(err, "failed to publish event %s", event.Type())
+	}
+	return nil
+}
+
+func (d *Dispatcher) receive(ctx context.Context, data []byte) error {
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return errors.Wrap(err, "failed to decode message from the bus")
+	}
+	if msg.Origin == d.origin {
+		return nil
+	}
+	rType := d.reflectType(msg.Type)
+	if rType == nil {
+		return fmt.Errorf("unable to reverse engineer the event %s, as it is not registered", msg.Type)
+	}
+	ptr := reflect.New(rType)
+	if err := d.codec.Unmarshal(msg.Data, ptr.Interface()); err != nil {
+		return errors.Wrapf(err, "failed to decode event %s", msg.Type)
+	}
+	if err := d.base.Dispatch(ctx, remoteEvent{events.Of(ptr.Elem().Interface())}); err != nil {
+		return errors.Wrapf(err, "failed to dispatch remote event %s", msg.Type)
+	}
+	return nil
+}
+
+func (d *Dispatcher) reflectType(typeName string) reflect.Type {
+	d.rwLock.RLock()
+	defer d.rwLock.RUnlock()
+	return d.reflectTypes[typeName]
+}
@@ -0,0 +1,211 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type CacheInvalidated struct {
+	Key string
+}
+
+type LocalEvent struct{}
+
+// mockTransport delivers the published messages to all receivers, including the publisher.
+type mockTransport struct {
+	lock      sync.Mutex
+	receivers []chan []byte
+	published int
+}
+
+func (m *mockTransport) Publish(ctx context.Context, message []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.published++
+	for _, ch := range m.receivers {
+		ch <- message
+	}
+	return nil
+}
+
+func (m *mockTransport) Receive(ctx context.Context, handle func(message []byte)) error {
+	ch := make(chan []byte, 10)
+	m.lock.Lock()
+	m.receivers = append(m.receivers, ch)
+	m.lock.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-ch:
+			handle(msg)
+		}
+	}
+}
+
+func (m *mockTransport) ready(n int) func() bool {
+	return func() bool {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		return len(m.receivers) == n
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	transport := &mockTransport{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type received struct {
+		key    string
+		remote bool
+	}
+	var replicas []*Dispatcher
+	results := make([]chan received, 2)
+	for i := range results {
+		ch := make(chan received, 10)
+		results[i] = ch
+		d := WithBus(&events.SyncDispatcher{}, transport)
+		d.Register(events.Of(CacheInvalidated{}))
+		d.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+			ch <- received{key: event.Data().(CacheInvalidated).Key, remote: IsRemote(event)}
+			return nil
+		}))
+		d.Subscribe(events.Listen(events.From(LocalEvent{}), func(ctx context.Context, event contract.Event) error {
+			ch <- received{key: "local"}
+			return nil
+		}))
+		go d.Run(ctx)
+		replicas = append(replicas, d)
+	}
+	assert.Eventually(t, transport.ready(2), time.Second, time.Millisecond)
+
+	assert.NoError(t, replicas[0].Dispatch(context.Background(), events.Of(CacheInvalidated{Key: "foo"})))
+	assert.Equal(t, received{key: "foo"}, <-results[0])
+	assert.Equal(t, received{key: "foo", remote: true}, <-results[1])
+
+	assert.NoError(t, replicas[1].Dispatch(context.Background(), events.Of(LocalEvent{})))
+	assert.Equal(t, received{key: "local"}, <-results[1])
+	assert.Equal(t, 1, transport.published)
+
+	select {
+	case r := <-results[0]:
+		t.Fatalf("unexpected event %+v", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+type CacheWarmed struct{}
+
+func TestDispatcher_dispatchFromRemoteListener(t *testing.T) {
+	transport := &mockTransport{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := WithBus(&events.SyncDispatcher{}, transport)
+	receiver := WithBus(&events.SyncDispatcher{}, transport)
+	warmed := make(chan bool, 1)
+	for _, d := range []*Dispatcher{sender, receiver} {
+		d.Register(events.Of(CacheInvalidated{}), events.Of(CacheWarmed{}))
+		go d.Run(ctx)
+	}
+	receiver.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+		if !IsRemote(event) {
+			return nil
+		}
+		return receiver.Dispatch(ctx, events.Of(CacheWarmed{}))
+	}))
+	sender.Subscribe(events.Listen(events.From(CacheWarmed{}), func(ctx context.Context, event contract.Event) error {
+		warmed <- IsRemote(event)
+		return nil
+	}))
+	assert.Eventually(t, transport.ready(2), time.Second, time.Millisecond)
+
+	assert.NoError(t, sender.Dispatch(context.Background(), events.Of(CacheInvalidated{Key: "foo"})))
+	select {
+	case remote := <-warmed:
+		assert.True(t, remote)
+	case <-time.After(time.Second):
+		t.Fatal("the event dispatched by the remote listener is not shared")
+	}
+}
+
+// failingTransport is a transport whose broker is down.
+type failingTransport struct{}
+
+func (f failingTransport) Publish(ctx context.Context, message []byte) error {
+	return errors.New("broker is down")
+}
+
+func (f failingTransport) Receive(ctx context.Context, handle func(message []byte)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestDispatcher_failingTransport(t *testing.T) {
+	var called int
+	d := WithBus(&events.SyncDispatcher{}, failingTransport{})
+	d.Register(events.Of(CacheInvalidated{}))
+	d.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+		called++
+		return nil
+	}))
+
+	err := d.Dispatch(context.Background(), events.Of(CacheInvalidated{Key: "foo"}))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broker is down")
+	assert.Equal(t, 1, called)
+
+	d.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+		return errors.New("listener failed")
+	}))
+	err = d.Dispatch(context.Background(), events.Of(CacheInvalidated{Key: "foo"}))
+	assert.Contains(t, err.Error(), "broker is down")
+	assert.Contains(t, err.Error(), "listener failed")
+	assert.Equal(t, 2, called)
+}
+
+func TestDispatcher_unregistered(t *testing.T) {
+	transport := &mockTransport{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := WithBus(&events.SyncDispatcher{}, transport)
+	sender.Register(events.Of(CacheInvalidated{}))
+	receiver := WithBus(&events.SyncDispatcher{}, transport)
+	receiver.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+		t.Fatal("unregistered events should not be delivered")
+		return nil
+	}))
+	done := make(chan struct{})
+	receiver.transport = receiveFunc(func(data []byte) {
+		assert.Error(t, receiver.receive(ctx, data))
+		close(done)
+	}, transport)
+	go receiver.Run(ctx)
+	assert.Eventually(t, transport.ready(1), time.Second, time.Millisecond)
+
+	assert.NoError(t, sender.Dispatch(context.Background(), events.Of(CacheInvalidated{Key: "foo"})))
+	<-done
+}
+
+// receiveFunc intercepts the messages received by the transport.
+func receiveFunc(fn func(data []byte), transport Transport) Transport {
+	return interceptor{Transport: transport, fn: fn}
+}
+
+type interceptor struct {
+	Transport
+	fn func(data []byte)
+}
+
+func (i interceptor) Receive(ctx context.Context, handle func(message []byte)) error {
+	return i.Transport.Receive(ctx, i.fn)
+}
@@ -0,0 +1,142 @@
+package eventbus
+
+import (
+	"fmt"
+
+	"github.com/DoNewsCode/core/config"
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/di"
+	"github.com/DoNewsCode/core/otkafka"
+	"github.com/DoNewsCode/core/otredis"
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/run"
+)
+
+/*
+Providers returns a set of dependency providers for the *Dispatcher.
+	Depends On:
+		contract.ConfigAccessor
+		contract.Dispatcher
+		log.Logger
+		Transport           `optional:"true"`
+		otredis.Maker       `optional:"true"`
+		otkafka.WriterMaker `optional:"true"`
+		otkafka.ReaderMaker `optional:"true"`
+	Provide:
+		*Dispatcher
+*/
+func Providers() di.Deps {
+	return []interface{}{provide, provideConfig}
+}
+
+// configuration is the structure of the eventbus config.
+type configuration struct {
+	Driver      string `yaml:"driver" json:"driver"`
+	RedisName   string `yaml:"redisName" json:"redisName"`
+	Channel     string `yaml:"channel" json:"channel"`
+	KafkaWriter string `yaml:"kafkaWriter" json:"kafkaWriter"`
+	KafkaReader string `yaml:"kafkaReader" json:"kafkaReader"`
+}
+
+type in struct {
+	di.In
+
+	Conf        contract.ConfigAccessor
+	Dispatcher  contract.Dispatcher
+	Logger      log.Logger
+	Transport   Transport           `optional:"true"`
+	RedisMaker  otredis.Maker       `optional:"true"`
+	WriterMaker otkafka.WriterMaker `optional:"true"`
+	ReaderMaker otkafka.ReaderMaker `optional:"true"`
+}
+
+type out struct {
+	di.Out
+
+	Dispatcher *Dispatcher
+}
+
+// ModuleSentinel marks out as module.
+func (m out) ModuleSentinel() {}
+
+// ProvideRunGroup implements container.RunProvider.
+func (m out) ProvideRunGroup(group *run.Group) {
+	m.Dispatcher.ProvideRunGroup(group)
+}
+
+func provide(in in) (out, error) {
+	if in.Transport == nil {
+		transport, err := provideTransport(in)
+		if err != nil {
+			return out{}, err
+		}
+		in.Transport = transport
+	}
+	return out{Dispatcher: WithBus(in.Dispatcher, in.Transport, UseLogger(in.Logger))}, nil
+}
+
+func provideTransport(in in) (Transport, error) {
+	var conf configuration
+	if err := in.Conf.Unmarshal("eventbus", &conf); err != nil {
+		return nil, fmt.Errorf("eventbus configuration error: %w", err)
+	}
+	switch conf.Driver {
+	case "", "redis":
+		if conf.RedisName == "" {
+			conf.RedisName = "default"
+		}
+		if in.RedisMaker == nil {
+			return nil, fmt.Errorf("eventbus driver redis requires an otredis.Maker")
+		}
+		client, err := in.RedisMaker.Make(conf.RedisName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make redis client %s for eventbus: %w", conf.RedisName, err)
+		}
+		return &RedisTransport{RedisClient: client, Channel: conf.Channel}, nil
+	case "kafka":
+		if conf.KafkaWriter == "" {
+			conf.KafkaWriter = "default"
+		}
+		if conf.KafkaReader == "" {
+			conf.KafkaReader = "default"
+		}
+		if in.WriterMaker == nil || in.ReaderMaker == nil {
+			return nil, fmt.Errorf("eventbus driver kafka requires an otkafka.WriterMaker and an otkafka.ReaderMaker")
+		}
+		writer, err := in.WriterMaker.Make(conf.KafkaWriter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make kafka writer %s for eventbus: %w", conf.KafkaWriter, err)
+		}
+		reader, err := in.ReaderMaker.Make(conf.KafkaReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make kafka reader %s for eventbus: %w", conf.KafkaReader, err)
+		}
+		return &KafkaTransport{Writer: writer, Reader: reader}, nil
+	default:
+		return nil, fmt.Errorf("unknown eventbus driver %s", conf.Driver)
+	}
+}
+
+type configOut struct {
+	di.Out
+
+	Config []config.ExportedConfig `group:"config,flatten"`
+}
+
+func provideConfig() configOut {
+	return configOut{Config: []config.ExportedConfig{
+		{
+			Owner: "eventbus",
+			Data: map[string]interface{}{
+				"eventbus": map[string]interface{}{
+					"driver":      "redis",
+					"redisName":   "default",
+					"channel":     "events",
+					"kafkaWriter": "default",
+					"kafkaReader": "default",
+				},
+			},
+			Comment: "The event bus config. The driver can be redis or kafka.",
+		},
+	}}
+}
@@ -0,0 +1,94 @@
+package eventbus
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/config"
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/go-kit/kit/log"
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type redisMaker struct{}
+
+func (r redisMaker) Make(name string) (redis.UniversalClient, error) {
+	return redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{os.Getenv("REDIS_ADDR")}}), nil
+}
+
+type kafkaMaker struct{}
+
+func (k kafkaMaker) Make(name string) (*kafka.Reader, error) {
+	return kafka.NewReader(kafka.ReaderConfig{Brokers: []string{"127.0.0.1:9092"}, Topic: "events"}), nil
+}
+
+type kafkaWriterMaker struct{}
+
+func (k kafkaWriterMaker) Make(name string) (*kafka.Writer, error) {
+	return &kafka.Writer{Topic: "events"}, nil
+}
+
+func TestProvide(t *testing.T) {
+	cases := []struct {
+		name     string
+		conf     configuration
+		expected Transport
+		err      bool
+	}{
+		{"default", configuration{}, &RedisTransport{}, false},
+		{"redis", configuration{Driver: "redis", Channel: "foo"}, &RedisTransport{}, false},
+		{"kafka", configuration{Driver: "kafka"}, &KafkaTransport{}, false},
+		{"unknown", configuration{Driver: "foo"}, nil, true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			out, err := provide(in{
+				Conf:        config.MapAdapter{"eventbus": c.conf},
+				Dispatcher:  &events.SyncDispatcher{},
+				Logger:      log.NewNopLogger(),
+				RedisMaker:  redisMaker{},
+				WriterMaker: kafkaWriterMaker{},
+				ReaderMaker: kafkaMaker{},
+			})
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, c.expected, out.Dispatcher.transport)
+		})
+	}
+
+	_, err := provide(in{Conf: config.MapAdapter{"eventbus": configuration{}}, Dispatcher: &events.SyncDispatcher{}, Logger: log.NewNopLogger()})
+	assert.Error(t, err)
+}
+
+func TestRedisTransport(t *testing.T) {
+	if os.Getenv("REDIS_ADDR") == "" {
+		t.Skip("Set env REDIS_ADDR to run TestRedisTransport")
+	}
+	client, _ := redisMaker{}.Make("default")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := WithBus(&events.SyncDispatcher{}, &RedisTransport{RedisClient: client, Channel: "eventbus-test"})
+	sender.Register(events.Of(CacheInvalidated{}))
+	receiver := WithBus(&events.SyncDispatcher{}, &RedisTransport{RedisClient: client, Channel: "eventbus-test"})
+	receiver.Register(events.Of(CacheInvalidated{}))
+	received := make(chan string)
+	receiver.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+		received <- event.Data().(CacheInvalidated).Key
+		return nil
+	}))
+	go receiver.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, sender.Dispatch(ctx, events.Of(CacheInvalidated{Key: "foo"})))
+	assert.Equal(t, "foo", <-received)
+}
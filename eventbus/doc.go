@@ -0,0 +1,43 @@
+/*
+Package eventbus shares events between processes, through redis Pub/Sub or
+kafka.
+
+Events created by events.Of only reach the listeners in the same process. When
+every replica of a service must react to an event, such as invalidating a local
+cache, wrap the dispatcher with a bus and register the event type:
+
+	bus := eventbus.WithBus(dispatcher, &eventbus.RedisTransport{RedisClient: client})
+	bus.Register(events.Of(CacheInvalidated{}))
+	bus.Subscribe(events.Listen(events.From(CacheInvalidated{}), func(ctx context.Context, event contract.Event) error {
+		cache.Delete(event.Data().(CacheInvalidated).Key)
+		return nil
+	}))
+	go bus.Run(ctx)
+
+	// in any replica
+	bus.Dispatch(ctx, events.Of(CacheInvalidated{Key: "foo"}))
+
+The event data is encoded by a Codec shared by all replicas, JSON by default.
+Like the queue package, the received data is decoded into the type registered
+under the event type, so all replicas must register the same types. Listeners can
+tell remote events apart with IsRemote. Events dispatched by the listeners of
+remote events are shared as usual.
+
+Delivery is at most once. Events published while a replica is down are not
+redelivered to it.
+
+Integrate
+
+The package also provides a *Dispatcher through dependency injection, built on
+top of the core contract.Dispatcher. The exported configuration is:
+
+	eventbus:
+	  driver: redis
+	  redisName: default
+	  channel: events
+
+To use kafka, set the driver to "kafka", and name the otkafka writer and reader
+with kafkaWriter and kafkaReader. See KafkaTransport for the requirements of
+the topic and the reader.
+*/
+package eventbus
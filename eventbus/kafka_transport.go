@@ -0,0 +1,41 @@
+package eventbus
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaTransport is a Transport backed by kafka. For every process to receive all messages, the Reader must not be
+// a consumer group reader, and the topic should have only one partition, which the Reader reads. Each process starts
+// reading from the latest offset, so messages published before Receive are skipped.
+type KafkaTransport struct {
+	Writer *kafka.Writer // Writer publishes the messages. It must have the topic configured.
+	Reader *kafka.Reader // Reader receives the messages. It must read the same topic without a consumer group.
+}
+
+// Publish implements Transport.
+func (k *KafkaTransport) Publish(ctx context.Context, message []byte) error {
+	return k.Writer.WriteMessages(ctx, kafka.Message{Value: message})
+}
+
+// Receive implements Transport.
+func (k *KafkaTransport) Receive(ctx context.Context, handle func(message []byte)) error {
+	if k.Reader.Config().GroupID != "" {
+		return errors.New("kafka transport requires a reader without consumer group")
+	}
+	if err := k.Reader.SetOffset(kafka.LastOffset); err != nil {
+		return errors.Wrap(err, "failed to seek to the latest offset")
+	}
+	for {
+		msg, err := k.Reader.ReadMessage(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read message")
+		}
+		handle(msg.Value)
+	}
+}
@@ -0,0 +1,53 @@
+package eventbus
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// RedisTransport is a Transport backed by redis Pub/Sub. Messages published while a process is not subscribed are
+// lost to that process.
+type RedisTransport struct {
+	RedisClient redis.UniversalClient // RedisClient is used to communicate with redis
+	Channel     string                // Channel is the Pub/Sub channel. By default, "events".
+}
+
+// Publish implements Transport.
+func (r *RedisTransport) Publish(ctx context.Context, message []byte) error {
+	return r.RedisClient.Publish(ctx, r.channel(), message).Err()
+}
+
+// Receive implements Transport.
+func (r *RedisTransport) Receive(ctx context.Context, handle func(message []byte)) error {
+	sub := r.RedisClient.Subscribe(ctx, r.channel())
+	defer sub.Close()
+
+	// Wait for the confirmation, so that errors are reported early.
+	if _, err := sub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to subscribe to channel %s", r.channel())
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			handle([]byte(msg.Payload))
+		}
+	}
+}
+
+func (r *RedisTransport) channel() string {
+	if r.Channel == "" {
+		return "events"
+	}
+	return r.Channel
+}
@@ -0,0 +1,177 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+)
+
+// ErrBufferFull is reported when the AsyncDispatcher drops an event because its buffer is full.
+var ErrBufferFull = errors.New("the buffer of the dispatcher is full")
+
+// ErrDispatcherClosed is returned when dispatching to a closed AsyncDispatcher.
+var ErrDispatcherClosed = errors.New("the dispatcher is closed")
+
+// AsyncDispatcher is a contract.Dispatcher implementation that dispatches events asynchronously. Dispatch returns
+// as soon as the event is buffered, and the listeners are called later by a pool of workers. Listeners of the same
+// event are called independently, so an error in one listener doesn't stop the others.
+//
+// As Dispatch doesn't wait for the listeners, their errors are reported to the ErrorHandler instead. The context
+// passed to listeners keeps the values of the dispatching context, but not its cancellation or deadline, since the
+// dispatcher is likely to have returned by then.
+//
+// The zero value is ready to use. AsyncDispatcher is safe for concurrent use. Call Close to wait for the buffered
+// events before exiting.
+type AsyncDispatcher struct {
+	// Workers is the number of goroutines calling listeners. By default, runtime.NumCPU().
+	Workers int
+	// BufferSize is the number of calls to listeners that can wait for the workers. By default, 1024.
+	BufferSize int
+	// DropWhenFull decides what Dispatch does when the buffer is full. If false, Dispatch blocks until there is
+	// room, or the context is done. If true, the listener calls that don't fit are dropped, and ErrBufferFull is
+	// reported to the ErrorHandler.
+	DropWhenFull bool
+	// ErrorHandler is called with the errors returned by listeners, including recovered panics. It may be called
+	// from many workers at the same time. If nil, the errors are discarded.
+	ErrorHandler func(ctx context.Context, event contract.Event, err error)
+
+	registry registry
+
+	once    sync.Once
+	jobs    chan asyncJob
+	wg      sync.WaitGroup
+	closed  bool
+	closeMu sync.RWMutex
+}
+
+type asyncJob struct {
+	ctx         context.Context
+	event       contract.Event
+	listener    contract.Listener
+	middlewares []Middleware
+}
+
+// Dispatch buffers the calls to the listeners of the event. It doesn't wait for them to finish.
+func (d *AsyncDispatcher) Dispatch(ctx context.Context, event contract.Event) error {
+	listeners, middlewares := d.registry.listeners(event.Type())
+	if len(listeners) == 0 {
+		return nil
+	}
+
+	d.once.Do(d.start)
+	d.closeMu.RLock()
+	defer d.closeMu.RUnlock()
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	jobCtx := detach(ctx)
+	for _, listener := range listeners {
+		job := asyncJob{ctx: jobCtx, event: event, listener: listener, middlewares: middlewares}
+		if d.DropWhenFull {
+			select {
+			case d.jobs <- job:
+			default:
+				d.report(jobCtx, event, ErrBufferFull)
+			}
+			continue
+		}
+		select {
+		case d.jobs <- job:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes the listener to the dispatcher. Listeners are buffered in the order of their priorities (see
+// Prioritized), but as they run concurrently, they may finish in any order. The events listened to can be patterns
+// (see Pattern).
+func (d *AsyncDispatcher) Subscribe(listener contract.Listener) {
+	d.registry.subscribe(listener)
+}
+
+// SubscribeWithHandle subscribes the listener to the dispatcher, and returns a Subscription to unsubscribe it later.
+// Events buffered before unsubscribing still reach the listener.
+func (d *AsyncDispatcher) SubscribeWithHandle(listener contract.Listener) *Subscription {
+	return d.registry.subscribe(listener)
+}
+
+// Use adds middlewares that wrap the Process method of every listener, including those subscribed earlier. The
+// first middleware is the outermost. Panics are always recovered, regardless of the middlewares.
+func (d *AsyncDispatcher) Use(middlewares ...Middleware) {
+	d.registry.use(middlewares)
+}
+
+// Close stops accepting events, and waits for the buffered ones to be handled.
+func (d *AsyncDispatcher) Close() {
+	d.once.Do(d.start)
+	d.closeMu.Lock()
+	if d.closed {
+		d.closeMu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.jobs)
+	d.closeMu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *AsyncDispatcher) start() {
+	workers := d.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	size := d.BufferSize
+	if size <= 0 {
+		size = 1024
+	}
+	d.jobs = make(chan asyncJob, size)
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer d.wg.Done()
+			for job := range d.jobs {
+				if err := process(job); err != nil {
+					d.report(job.ctx, job.event, err)
+				}
+			}
+		}()
+	}
+}
+
+func (d *AsyncDispatcher) report(ctx context.Context, event contract.Event, err error) {
+	if d.ErrorHandler != nil {
+		d.ErrorHandler(ctx, event, err)
+	}
+}
+
+// process calls the listener through the middlewares, converting panics to PanicError.
+func process(job asyncJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handle(job.ctx, job.listener, job.event, job.middlewares)
+}
+
+// detachedContext keeps the values of the parent context, but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
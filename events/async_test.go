@@ -0,0 +1,104 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestAsyncDispatcher(t *testing.T) {
+	t.Parallel()
+	var (
+		mu     sync.Mutex
+		values []int
+		errs   []error
+	)
+	dispatcher := AsyncDispatcher{
+		Workers: 2,
+		ErrorHandler: func(ctx context.Context, event contract.Event, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	}
+	dispatcher.Subscribe(Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+		assert.NoError(t, ctx.Err())
+		assert.Equal(t, "bar", ctx.Value(ctxKey{}))
+		mu.Lock()
+		defer mu.Unlock()
+		values = append(values, event.Data().(MockEvent).value)
+		return nil
+	}))
+	dispatcher.Subscribe(Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+		return errors.New("foo")
+	}))
+	dispatcher.Subscribe(Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+		panic("baz")
+	}))
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "bar"))
+	assert.NoError(t, dispatcher.Dispatch(ctx, Of(MockEvent{value: 1})))
+	assert.NoError(t, dispatcher.Dispatch(ctx, Of(MockEvent{value: 2})))
+	assert.NoError(t, dispatcher.Dispatch(ctx, Of(struct{}{})))
+	cancel()
+	dispatcher.Close()
+
+	assert.ElementsMatch(t, []int{1, 2}, values)
+	assert.Len(t, errs, 4)
+	assert.Equal(t, ErrDispatcherClosed, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+	dispatcher.Close()
+}
+
+func TestAsyncDispatcher_full(t *testing.T) {
+	t.Parallel()
+	for _, drop := range []bool{false, true} {
+		drop := drop
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			var (
+				mu      sync.Mutex
+				dropped int
+				release = make(chan struct{})
+			)
+			dispatcher := AsyncDispatcher{
+				Workers:      1,
+				BufferSize:   1,
+				DropWhenFull: drop,
+				ErrorHandler: func(ctx context.Context, event contract.Event, err error) {
+					assert.Equal(t, ErrBufferFull, err)
+					mu.Lock()
+					defer mu.Unlock()
+					dropped++
+				},
+			}
+			dispatcher.Subscribe(Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+				<-release
+				return nil
+			}))
+			// one event is held by the worker, and the other fills the buffer.
+			assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+			assert.Eventually(t, func() bool { return len(dispatcher.jobs) == 0 }, time.Second, time.Millisecond)
+			assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			err := dispatcher.Dispatch(ctx, Of(MockEvent{}))
+			close(release)
+			dispatcher.Close()
+			if drop {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, dropped)
+				return
+			}
+			assert.Equal(t, context.DeadlineExceeded, err)
+			assert.Equal(t, 0, dropped)
+		})
+	}
+}
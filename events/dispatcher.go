@@ -2,45 +2,56 @@ package events
 
 import (
 	"context"
-	"sync"
 
 	"github.com/DoNewsCode/core/contract"
+	"github.com/go-kit/kit/log"
 )
 
 // SyncDispatcher is a contract.Dispatcher implementation that dispatches events synchronously.
 // SyncDispatcher is safe for concurrent use.
 type SyncDispatcher struct {
-	registry map[string][]contract.Listener
-	rwLock   sync.RWMutex
+	// ErrorStrategy decides what to do when a listener returns an error. By
+	// default, the process is aborted immediately. It can be overridden for each
+	// dispatch by WithErrorStrategy.
+	ErrorStrategy ErrorStrategy
+	// Logger logs the errors of listeners when the ErrorStrategy is LogErrors. If
+	// nil, the errors are discarded.
+	Logger log.Logger
+
+	registry registry
 }
 
 // Dispatch dispatches events synchronously. If any listener returns an error,
-// abort the process immediately and return that error to caller.
+// it is handled by the ErrorStrategy. By default, abort the process immediately
+// and return that error to caller.
 func (d *SyncDispatcher) Dispatch(ctx context.Context, event contract.Event) error {
-	d.rwLock.RLock()
-	listeners, ok := d.registry[event.Type()]
-	d.rwLock.RUnlock()
-
-	if !ok {
-		return nil
-	}
-	for _, listener := range listeners {
-		if err := listener.Process(ctx, event); err != nil {
-			return err
-		}
-	}
-	return nil
+	listeners, middlewares := d.registry.listeners(event.Type())
+	return callAll(ctx, errorStrategy(ctx, d.ErrorStrategy), d.Logger, listeners, event, middlewares)
 }
 
-// Subscribe subscribes the listener to the dispatcher.
+// Subscribe subscribes the listener to the dispatcher. Listeners are called in
+// the order of their priorities (see Prioritized), and then the order they
+// subscribed. The events listened to can be patterns (see Pattern).
 func (d *SyncDispatcher) Subscribe(listener contract.Listener) {
-	d.rwLock.Lock()
-	defer d.rwLock.Unlock()
+	d.registry.subscribe(listener)
+}
 
-	if d.registry == nil {
-		d.registry = make(map[string][]contract.Listener)
-	}
-	for _, e := range listener.Listen() {
-		d.registry[e.Type()] = append(d.registry[e.Type()], listener)
+// SubscribeWithHandle subscribes the listener to the dispatcher, and returns a
+// Subscription to unsubscribe it later.
+func (d *SyncDispatcher) SubscribeWithHandle(listener contract.Listener) *Subscription {
+	return d.registry.subscribe(listener)
+}
+
+// Use adds middlewares that wrap the Process method of every listener, including
+// those subscribed earlier. The first middleware is the outermost.
+func (d *SyncDispatcher) Use(middlewares ...Middleware) {
+	d.registry.use(middlewares)
+}
+
+// handle calls the listener through the middlewares.
+func handle(ctx context.Context, listener contract.Listener, event contract.Event, middlewares []Middleware) error {
+	if len(middlewares) == 0 {
+		return listener.Process(ctx, event)
 	}
+	return Chain(middlewares...)(listener, listener.Process)(ctx, event)
 }
@@ -6,6 +6,25 @@ listeners in a synchronous, sequential execution. The synchronous listener is
 only a "go" away from an asynchronous handler, but asynchronous listener can not
 be easily made synchronous.
 
+For listeners that are slow and whose outcome the dispatcher doesn't care about,
+such as audit logs, use the AsyncDispatcher. It calls listeners in a pool of
+workers, and reports their errors to a callback.
+
+Listeners are called in the order they subscribed, unless they implement
+Prioritized. To listen to all events from a package, subscribe to a Pattern,
+such as events.Pattern("github.com/foo/bar.*"). Modules that reload their
+listeners can use SubscribeWithHandle, and unsubscribe with the handle later.
+
+By default, SyncDispatcher stops at the first listener returning an error. Set
+its ErrorStrategy to call all listeners, and either aggregate the errors or log
+them. The strategy can also be chosen for each dispatch with WithErrorStrategy.
+
+Listeners can be wrapped by middlewares, to add tracing, metrics, logging or
+panic recovery to all of them. See SyncDispatcher.Use and Middleware.
+
+	dispatcher := &events.SyncDispatcher{}
+	dispatcher.Use(events.Recover(), events.Trace(tracer), events.Log(logger))
+
 The event listeners can also be used as hooks. If the event data is a pointer type,
 listeners may alter the data. This enables plugin/addon style decoupling.
 
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/logging"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// Handler processes an event. It has the same signature as contract.Listener's Process method.
+type Handler func(ctx context.Context, event contract.Event) error
+
+// Middleware wraps the Process method of listeners. The listener being wrapped is passed in, so that the
+// middleware can tell the listeners apart, for example in logs and spans. Middlewares must call next to reach the
+// listener.
+type Middleware func(listener contract.Listener, next Handler) Handler
+
+// Chain composes middlewares into one. The first middleware is the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(listener contract.Listener, next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](listener, next)
+		}
+		return next
+	}
+}
+
+// Wrap returns a listener that calls the middlewares around the Process method of the given listener. It listens to
+// the same events, with the same priority. Wrap is useful for dispatchers that don't accept middlewares by
+// themselves.
+func Wrap(listener contract.Listener, middlewares ...Middleware) contract.Listener {
+	if len(middlewares) == 0 {
+		return listener
+	}
+	var wrapped contract.Listener = funcListener{
+		events:   listener.Listen(),
+		callback: Chain(middlewares...)(listener, listener.Process),
+	}
+	if p, ok := listener.(Prioritized); ok {
+		wrapped = WithPriority(wrapped, p.Priority())
+	}
+	return wrapped
+}
+
+// PanicError is returned by the Recover middleware when a listener panics.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+// Error implements error.
+func (p PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// Recover is a Middleware that converts panics in listeners to PanicError, so that a panicking listener won't take
+// down the goroutine dispatching the event.
+func Recover() Middleware {
+	return func(listener contract.Listener, next Handler) Handler {
+		return func(ctx context.Context, event contract.Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// Trace is a Middleware that starts an opentracing span for each event and listener. The span is a child of the
+// span in the context, if any. Errors are tagged on the span.
+func Trace(tracer opentracing.Tracer) Middleware {
+	return func(listener contract.Listener, next Handler) Handler {
+		name := fmt.Sprintf("%T", listener)
+		return func(ctx context.Context, event contract.Event) error {
+			span, ctx := opentracing.StartSpanFromContextWithTracer(ctx, tracer, "event:"+event.Type())
+			defer span.Finish()
+			ext.Component.Set(span, "events")
+			span.SetTag("listener", name)
+			err := next(ctx, event)
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.LogKV("error", err.Error())
+			}
+			return err
+		}
+	}
+}
+
+// Measure is a Middleware that observes the seconds taken by listeners in the histogram, labeled by "event". The
+// value of the label is the event type.
+func Measure(histogram metrics.Histogram) Middleware {
+	return func(listener contract.Listener, next Handler) Handler {
+		return func(ctx context.Context, event contract.Event) error {
+			start := time.Now()
+			defer func() {
+				histogram.With("event", event.Type()).Observe(time.Since(start).Seconds())
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// Log is a Middleware that logs the errors returned by listeners, along with the values from the context. See
+// logging.WithContext.
+func Log(logger log.Logger) Middleware {
+	return func(listener contract.Listener, next Handler) Handler {
+		name := fmt.Sprintf("%T", listener)
+		return func(ctx context.Context, event contract.Event) error {
+			err := next(ctx, event)
+			if err != nil {
+				logError(ctx, logger, event, name, err)
+			}
+			return err
+		}
+	}
+}
+
+// logError logs the error returned by the listener.
+func logError(ctx context.Context, logger log.Logger, event contract.Event, listener string, err error) {
+	_ = level.Warn(logging.WithContext(logger, ctx)).Log(
+		"msg", "listener failed", "event", event.Type(), "listener", listener, "err", err,
+	)
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockHistogram struct {
+	labelValues []string
+	observed    int
+}
+
+func (m *mockHistogram) With(labelValues ...string) metrics.Histogram {
+	m.labelValues = labelValues
+	return m
+}
+
+func (m *mockHistogram) Observe(value float64) {
+	m.observed++
+}
+
+func TestSyncDispatcher_Use(t *testing.T) {
+	var (
+		order  []string
+		tracer = mocktracer.New()
+		hist   = &mockHistogram{}
+		buf    bytes.Buffer
+	)
+	dispatcher := SyncDispatcher{}
+	dispatcher.Subscribe(Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+		order = append(order, "listener")
+		return errors.New("foo")
+	}))
+	dispatcher.Use(
+		func(listener contract.Listener, next Handler) Handler {
+			return func(ctx context.Context, event contract.Event) error {
+				order = append(order, "first")
+				return next(ctx, event)
+			}
+		},
+		func(listener contract.Listener, next Handler) Handler {
+			return func(ctx context.Context, event contract.Event) error {
+				order = append(order, "second")
+				return next(ctx, event)
+			}
+		},
+		Trace(tracer),
+		Measure(hist),
+		Log(log.NewLogfmtLogger(&buf)),
+	)
+
+	err := dispatcher.Dispatch(context.Background(), Of(MockEvent{}))
+	assert.EqualError(t, err, "foo")
+	assert.Equal(t, []string{"first", "second", "listener"}, order)
+	assert.Len(t, tracer.FinishedSpans(), 1)
+	assert.Equal(t, "event:github.com/DoNewsCode/core/events.MockEvent", tracer.FinishedSpans()[0].OperationName)
+	assert.Equal(t, true, tracer.FinishedSpans()[0].Tag("error"))
+	assert.Equal(t, []string{"event", "github.com/DoNewsCode/core/events.MockEvent"}, hist.labelValues)
+	assert.Equal(t, 1, hist.observed)
+	assert.Contains(t, buf.String(), "err=foo")
+	assert.Contains(t, buf.String(), "event=github.com/DoNewsCode/core/events.MockEvent")
+}
+
+func TestRecover(t *testing.T) {
+	dispatcher := SyncDispatcher{}
+	dispatcher.Use(Recover())
+	dispatcher.Subscribe(Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+		panic("foo")
+	}))
+	err := dispatcher.Dispatch(context.Background(), Of(MockEvent{}))
+	var panicErr PanicError
+	assert.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, "foo", panicErr.Value)
+	assert.NotEmpty(t, panicErr.Stack)
+}
+
+func TestWrap(t *testing.T) {
+	var called bool
+	listener := Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+		panic("foo")
+	})
+	wrapped := Wrap(listener, Recover(), func(l contract.Listener, next Handler) Handler {
+		assert.Equal(t, listener.Listen(), l.Listen())
+		called = true
+		return next
+	})
+	assert.Equal(t, listener.Listen(), wrapped.Listen())
+	assert.Error(t, wrapped.Process(context.Background(), Of(MockEvent{})))
+	assert.True(t, called)
+}
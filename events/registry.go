@@ -0,0 +1,194 @@
+package events
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DoNewsCode/core/contract"
+)
+
+// Prioritized can be implemented by listeners to decide the order they are called in. Listeners with higher
+// priority are called first. Listeners with the same priority, including those not implementing Prioritized (which
+// have priority 0), are called in the order they subscribed.
+type Prioritized interface {
+	Priority() int
+}
+
+// HandleSubscriber is implemented by dispatchers whose subscriptions can be canceled. It extends
+// contract.Dispatcher without breaking the existing implementations.
+type HandleSubscriber interface {
+	contract.Dispatcher
+	// SubscribeWithHandle subscribes the listener like Subscribe does, and returns a Subscription to cancel it.
+	SubscribeWithHandle(listener contract.Listener) *Subscription
+}
+
+// Subscription is a listener subscribed to a dispatcher. It can be used to unsubscribe the listener, for example
+// when the module owning the listener reloads.
+type Subscription struct {
+	once        sync.Once
+	unsubscribe func()
+}
+
+// Unsubscribe removes the listener from the dispatcher. Events dispatched afterwards won't reach the listener.
+// Calling Unsubscribe more than once has no effect.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(s.unsubscribe)
+}
+
+// WithPriority returns a listener with the given priority, which otherwise behaves like the given one. See
+// Prioritized.
+func WithPriority(listener contract.Listener, priority int) contract.Listener {
+	return prioritizedListener{Listener: listener, priority: priority}
+}
+
+type prioritizedListener struct {
+	contract.Listener
+	priority int
+}
+
+func (p prioritizedListener) Priority() int {
+	return p.priority
+}
+
+// Pattern creates an event for listeners to subscribe to every event whose type matches the pattern. A "*" at the
+// end of the pattern matches any suffix, so "github.com/foo/bar.*" matches all events from the package
+// github.com/foo/bar, and "*" matches all events. Patterns without "*" match the exact type.
+//
+//  func (a AuditListener) Listen() []contract.Event {
+//    return []contract.Event{events.Pattern("github.com/foo/bar.*")}
+//  }
+//
+// Patterns are only understood by the dispatchers in this package. The event data of a pattern is nil.
+func Pattern(pattern string) contract.Event {
+	return patternEvent(pattern)
+}
+
+type patternEvent string
+
+func (p patternEvent) Type() string {
+	return string(p)
+}
+
+func (p patternEvent) Data() interface{} {
+	return nil
+}
+
+// entry is a listener subscribed to an event type or a pattern.
+type entry struct {
+	listener contract.Listener
+	priority int
+	seq      uint64
+}
+
+// registry stores the listeners of a dispatcher. The zero value is ready to use. It is safe for concurrent use.
+type registry struct {
+	rwLock      sync.RWMutex
+	exact       map[string][]*entry
+	prefixes    map[string][]*entry
+	middlewares []Middleware
+	seq         uint64
+}
+
+// subscribe adds the listener for all the events it listens to.
+func (r *registry) subscribe(listener contract.Listener) *Subscription {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+
+	if r.exact == nil {
+		r.exact = make(map[string][]*entry)
+		r.prefixes = make(map[string][]*entry)
+	}
+	r.seq++
+	e := &entry{listener: listener, seq: r.seq}
+	if p, ok := listener.(Prioritized); ok {
+		e.priority = p.Priority()
+	}
+	for _, evt := range listener.Listen() {
+		if prefix := evt.Type(); strings.HasSuffix(prefix, "*") {
+			prefix = strings.TrimSuffix(prefix, "*")
+			r.prefixes[prefix] = insert(r.prefixes[prefix], e)
+			continue
+		}
+		r.exact[evt.Type()] = insert(r.exact[evt.Type()], e)
+	}
+	return &Subscription{unsubscribe: func() { r.unsubscribe(e) }}
+}
+
+// unsubscribe removes the entry from all event types and patterns.
+func (r *registry) unsubscribe(e *entry) {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+
+	for _, m := range []map[string][]*entry{r.exact, r.prefixes} {
+		for key, entries := range m {
+			kept := entries[:0:0]
+			for _, existing := range entries {
+				if existing != e {
+					kept = append(kept, existing)
+				}
+			}
+			if len(kept) == 0 {
+				delete(m, key)
+				continue
+			}
+			m[key] = kept
+		}
+	}
+}
+
+// use adds the middlewares.
+func (r *registry) use(middlewares []Middleware) {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// listeners returns the listeners of the event type in the order to call them, along with the middlewares. A
+// listener subscribed to both the type and a matching pattern is returned once.
+func (r *registry) listeners(eventType string) ([]contract.Listener, []Middleware) {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+
+	entries := r.exact[eventType]
+	if len(r.prefixes) > 0 {
+		entries = append([]*entry(nil), entries...)
+		for prefix, matched := range r.prefixes {
+			if strings.HasPrefix(eventType, prefix) {
+				entries = append(entries, matched...)
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return less(entries[i], entries[j])
+		})
+	}
+
+	listeners := make([]contract.Listener, 0, len(entries))
+	for i, e := range entries {
+		if i > 0 && entries[i-1] == e {
+			continue
+		}
+		listeners = append(listeners, e.listener)
+	}
+	return listeners, r.middlewares
+}
+
+// insert adds the entry to the sorted entries.
+func insert(entries []*entry, e *entry) []*entry {
+	i := sort.Search(len(entries), func(i int) bool {
+		return less(e, entries[i])
+	})
+	entries = append(entries, nil)
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	return entries
+}
+
+// less reports whether a should be called before b.
+func less(a, b *entry) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/stretchr/testify/assert"
+)
+
+type OtherEvent struct{}
+
+func TestSyncDispatcher_priority(t *testing.T) {
+	var order []string
+	record := func(name string) contract.Listener {
+		return Listen(From(MockEvent{}), func(ctx context.Context, event contract.Event) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+	dispatcher := SyncDispatcher{}
+	dispatcher.Subscribe(record("a"))
+	dispatcher.Subscribe(WithPriority(record("b"), -1))
+	dispatcher.Subscribe(WithPriority(record("c"), 10))
+	dispatcher.Subscribe(record("d"))
+	dispatcher.Subscribe(Wrap(WithPriority(record("e"), 5), Recover()))
+
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+	assert.Equal(t, []string{"c", "e", "a", "d", "b"}, order)
+}
+
+func TestSyncDispatcher_SubscribeWithHandle(t *testing.T) {
+	var called int
+	dispatcher := SyncDispatcher{}
+	sub := dispatcher.SubscribeWithHandle(Listen(From(MockEvent{}, OtherEvent{}), func(ctx context.Context, event contract.Event) error {
+		called++
+		return nil
+	}))
+	var _ HandleSubscriber = &dispatcher
+
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(OtherEvent{})))
+	assert.Equal(t, 1, called)
+	assert.Empty(t, dispatcher.registry.exact)
+}
+
+func TestSyncDispatcher_pattern(t *testing.T) {
+	var seen []string
+	record := func(name string, evts ...contract.Event) contract.Listener {
+		return Listen(evts, func(ctx context.Context, event contract.Event) error {
+			seen = append(seen, name+":"+event.Type())
+			return nil
+		})
+	}
+	dispatcher := SyncDispatcher{}
+	dispatcher.Subscribe(record("exact", Of(MockEvent{})))
+	dispatcher.Subscribe(WithPriority(record("package", Pattern("github.com/DoNewsCode/core/events.*")), 1))
+	dispatcher.Subscribe(record("all", Pattern("*"), Of(MockEvent{})))
+	dispatcher.Subscribe(record("none", Pattern("github.com/DoNewsCode/core/eventsx.*")))
+	sub := dispatcher.SubscribeWithHandle(record("other", Pattern("github.com/DoNewsCode/core/events.Other*")))
+
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(OtherEvent{})))
+	sub.Unsubscribe()
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(OtherEvent{})))
+	assert.Equal(t, []string{
+		"package:github.com/DoNewsCode/core/events.MockEvent",
+		"exact:github.com/DoNewsCode/core/events.MockEvent",
+		"all:github.com/DoNewsCode/core/events.MockEvent",
+		"package:github.com/DoNewsCode/core/events.OtherEvent",
+		"all:github.com/DoNewsCode/core/events.OtherEvent",
+		"other:github.com/DoNewsCode/core/events.OtherEvent",
+		"package:github.com/DoNewsCode/core/events.OtherEvent",
+		"all:github.com/DoNewsCode/core/events.OtherEvent",
+	}, seen)
+}
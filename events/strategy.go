@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/go-multierror"
+)
+
+// ErrorStrategy decides what SyncDispatcher does when a listener returns an error.
+type ErrorStrategy int
+
+const (
+	// StopOnError returns the first error, skipping the remaining listeners. It is the default.
+	StopOnError ErrorStrategy = iota
+	// AggregateErrors calls all listeners, and returns the errors in a *multierror.Error. Each error in it is a
+	// ListenerError.
+	AggregateErrors
+	// LogErrors calls all listeners, and logs the errors instead of returning them.
+	LogErrors
+)
+
+// ListenerError is an error returned by a listener, used by the AggregateErrors strategy. Use errors.As to find out
+// which listener has failed.
+type ListenerError struct {
+	// Listener is the failed listener.
+	Listener contract.Listener
+	// Err is the error returned by the listener.
+	Err error
+}
+
+// Error implements error.
+func (l ListenerError) Error() string {
+	return fmt.Sprintf("listener %T: %s", l.Listener, l.Err)
+}
+
+// Unwrap returns the error returned by the listener.
+func (l ListenerError) Unwrap() error {
+	return l.Err
+}
+
+type strategyKey struct{}
+
+// WithErrorStrategy returns a context that overrides the ErrorStrategy of the dispatcher, for the events dispatched
+// with it.
+//
+//  err := dispatcher.Dispatch(events.WithErrorStrategy(ctx, events.AggregateErrors), event)
+func WithErrorStrategy(ctx context.Context, strategy ErrorStrategy) context.Context {
+	return context.WithValue(ctx, strategyKey{}, strategy)
+}
+
+// errorStrategy returns the strategy in the context, or the fallback if there is none.
+func errorStrategy(ctx context.Context, fallback ErrorStrategy) ErrorStrategy {
+	if strategy, ok := ctx.Value(strategyKey{}).(ErrorStrategy); ok {
+		return strategy
+	}
+	return fallback
+}
+
+// callAll calls every listener, and handles the errors by the strategy.
+func callAll(ctx context.Context, strategy ErrorStrategy, logger log.Logger, listeners []contract.Listener, event contract.Event, middlewares []Middleware) error {
+	var errs *multierror.Error
+	for _, listener := range listeners {
+		err := handle(ctx, listener, event, middlewares)
+		if err == nil {
+			continue
+		}
+		switch strategy {
+		case AggregateErrors:
+			errs = multierror.Append(errs, ListenerError{Listener: listener, Err: err})
+		case LogErrors:
+			if logger != nil {
+				logError(ctx, logger, event, fmt.Sprintf("%T", listener), err)
+			}
+		default:
+			return err
+		}
+	}
+	return errs.ErrorOrNil()
+}
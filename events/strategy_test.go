@@ -0,0 +1,69 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingListener struct {
+	err    error
+	called *int
+}
+
+func (f failingListener) Listen() []contract.Event {
+	return From(MockEvent{})
+}
+
+func (f failingListener) Process(ctx context.Context, event contract.Event) error {
+	*f.called++
+	return f.err
+}
+
+func TestSyncDispatcher_ErrorStrategy(t *testing.T) {
+	var (
+		called int
+		buf    bytes.Buffer
+		foo    = errors.New("foo")
+		bar    = errors.New("bar")
+	)
+	dispatcher := SyncDispatcher{Logger: log.NewLogfmtLogger(&buf)}
+	dispatcher.Subscribe(failingListener{err: foo, called: &called})
+	dispatcher.Subscribe(failingListener{called: &called})
+	dispatcher.Subscribe(failingListener{err: bar, called: &called})
+
+	err := dispatcher.Dispatch(context.Background(), Of(MockEvent{}))
+	assert.Equal(t, foo, err)
+	assert.Equal(t, 1, called)
+
+	called = 0
+	err = dispatcher.Dispatch(WithErrorStrategy(context.Background(), AggregateErrors), Of(MockEvent{}))
+	assert.Equal(t, 3, called)
+	var merr *multierror.Error
+	assert.True(t, errors.As(err, &merr))
+	assert.Len(t, merr.Errors, 2)
+	var listenerErr ListenerError
+	assert.True(t, errors.As(merr.Errors[1], &listenerErr))
+	assert.Equal(t, bar, listenerErr.Err)
+	assert.True(t, errors.Is(err, foo))
+
+	called = 0
+	dispatcher.ErrorStrategy = LogErrors
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Of(MockEvent{})))
+	assert.Equal(t, 3, called)
+	assert.Contains(t, buf.String(), "err=foo")
+	assert.Contains(t, buf.String(), "err=bar")
+
+	called = 0
+	err = dispatcher.Dispatch(WithErrorStrategy(context.Background(), StopOnError), Of(MockEvent{}))
+	assert.Equal(t, foo, err)
+	assert.Equal(t, 1, called)
+
+	assert.NoError(t, dispatcher.Dispatch(WithErrorStrategy(context.Background(), AggregateErrors), Of(struct{}{})))
+}
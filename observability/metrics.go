@@ -6,6 +6,7 @@ import (
 	"github.com/DoNewsCode/core/contract"
 	"github.com/DoNewsCode/core/otgorm"
 	"github.com/DoNewsCode/core/otredis"
+	"github.com/DoNewsCode/core/queue"
 	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/prometheus"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
@@ -100,3 +101,40 @@ func ProvideRedisMetrics(appName contract.AppName, env contract.Env) *otredis.Ga
 		}, []string{"dbname"}),
 	}
 }
+
+// ProvideQueueMetrics returns a *queue.Metrics that measures the handling of persisted events.
+// It is meant to be consumed by the queue.Providers.
+func ProvideQueueMetrics(appName contract.AppName, env contract.Env) *queue.Metrics {
+	return &queue.Metrics{
+		Duration: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: appName.String(),
+			Subsystem: env.String(),
+			Name:      "queue_handle_duration_seconds",
+			Help:      "Total time spent handling events",
+		}, []string{"queue", "event"}),
+		Latency: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: appName.String(),
+			Subsystem: env.String(),
+			Name:      "queue_wait_duration_seconds",
+			Help:      "Time spent by events waiting in queue",
+		}, []string{"queue", "event"}),
+		Processed: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: appName.String(),
+			Subsystem: env.String(),
+			Name:      "queue_processed_total",
+			Help:      "number of events handled successfully",
+		}, []string{"queue", "event"}),
+		Retried: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: appName.String(),
+			Subsystem: env.String(),
+			Name:      "queue_retried_total",
+			Help:      "number of failed attempts to be retried",
+		}, []string{"queue", "event"}),
+		Aborted: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: appName.String(),
+			Subsystem: env.String(),
+			Name:      "queue_aborted_total",
+			Help:      "number of events failed after max attempts",
+		}, []string{"queue", "event"}),
+	}
+}
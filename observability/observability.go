@@ -17,6 +17,9 @@ Providers returns a set of providers available in package observability
 	Provides:
 		opentracing.Tracer
 		metrics.Histogram
+		*otgorm.Gauges
+		*otredis.Gauges
+		*queue.Metrics
 */
 func Providers() di.Deps {
 	return di.Deps{
@@ -25,6 +28,7 @@ func Providers() di.Deps {
 		ProvideHistogramMetrics,
 		ProvideGORMMetrics,
 		ProvideRedisMetrics,
+		ProvideQueueMetrics,
 		provideConfig,
 	}
 }
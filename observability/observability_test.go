@@ -5,6 +5,7 @@ import (
 	"github.com/DoNewsCode/core/config"
 	"github.com/DoNewsCode/core/otgorm"
 	"github.com/DoNewsCode/core/otredis"
+	"github.com/DoNewsCode/core/queue"
 	"github.com/go-kit/kit/log"
 	"github.com/go-redis/redis/v8"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -90,6 +91,20 @@ func TestProvideRedisMetrics(t *testing.T) {
 	})
 }
 
+func TestProvideQueueMetrics(t *testing.T) {
+	m := ProvideQueueMetrics(
+		config.AppName("foo"),
+		config.EnvTesting,
+	)
+	withValues := []string{"queue", "default", "event", "foo"}
+	m.Duration.With(withValues...).Observe(1)
+	m.Latency.With(withValues...).Observe(1)
+	m.Processed.With(withValues...).Add(1)
+	m.Retried.With(withValues...).Add(1)
+	m.Aborted.With(withValues...).Add(1)
+	assert.IsType(t, &queue.Metrics{}, m)
+}
+
 func Test_provideConfig(t *testing.T) {
 	Conf := provideConfig()
 	assert.NotEmpty(t, Conf.Config)
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// FixedBackoffPolicy waits for PersistedEvent.Backoff before every retry.
+	FixedBackoffPolicy = "fixed"
+	// ExponentialBackoffPolicy waits for PersistedEvent.Backoff before the first
+	// retry, and doubles the duration for each subsequent retry. A random jitter
+	// is applied to spread out the retries. The duration never exceeds 10 minutes.
+	ExponentialBackoffPolicy = "exponential"
+)
+
+const maxBackoff = 10 * time.Minute
+
+// BackoffPolicy computes how long a failed message should wait before the next
+// attempt. The message passed in still carries the number of attempts made so
+// far, including the failed one.
+type BackoffPolicy func(message *PersistedEvent) time.Duration
+
+var backoffPolicies = struct {
+	sync.RWMutex
+	policies map[string]BackoffPolicy
+}{
+	policies: map[string]BackoffPolicy{
+		FixedBackoffPolicy:       fixedBackoff,
+		ExponentialBackoffPolicy: exponentialBackoff,
+	},
+}
+
+// RegisterBackoffPolicy registers a custom BackoffPolicy under the given name.
+// The policy is referenced by name in the persisted message, so it must be
+// registered in every process that consumes the queue, usually during
+// bootstrap. Registering a name twice overwrites the previous policy.
+//
+//	queue.RegisterBackoffPolicy("linear", func(message *queue.PersistedEvent) time.Duration {
+//		return time.Duration(message.Attempts) * message.Backoff
+//	})
+//	dispatcher.Dispatch(ctx, queue.Persist(event, queue.Backoff("linear", time.Second)))
+func RegisterBackoffPolicy(name string, policy BackoffPolicy) {
+	backoffPolicies.Lock()
+	defer backoffPolicies.Unlock()
+	backoffPolicies.policies[name] = policy
+}
+
+// nextBackoff returns the duration before the next retry of the message. It
+// should be called by drivers before the attempt counter is increased.
+func nextBackoff(message *PersistedEvent) time.Duration {
+	backoffPolicies.RLock()
+	policy, ok := backoffPolicies.policies[message.BackoffPolicy]
+	backoffPolicies.RUnlock()
+	if !ok {
+		// Messages without a policy double their backoff on every retry.
+		message.Backoff = getRetryDuration(message.Backoff)
+		return message.Backoff
+	}
+	return policy(message)
+}
+
+func fixedBackoff(message *PersistedEvent) time.Duration {
+	return message.Backoff
+}
+
+func exponentialBackoff(message *PersistedEvent) time.Duration {
+	exp := message.Attempts - 1
+	if exp < 0 {
+		exp = 0
+	}
+	d := float64(message.Backoff) * math.Pow(2, float64(exp))
+	d *= rand.Float64() + 0.5
+	if d > float64(maxBackoff) {
+		return maxBackoff
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextBackoff(t *testing.T) {
+	RegisterBackoffPolicy("linear", func(message *PersistedEvent) time.Duration {
+		return time.Duration(message.Attempts) * message.Backoff
+	})
+
+	cases := []struct {
+		name     string
+		message  PersistedEvent
+		min, max time.Duration
+	}{
+		{"legacy", PersistedEvent{Attempts: 1}, time.Second, 10 * time.Minute},
+		{"unknown policy", PersistedEvent{Attempts: 1, BackoffPolicy: "foo"}, time.Second, 10 * time.Minute},
+		{"fixed", PersistedEvent{Attempts: 3, Backoff: 5 * time.Second, BackoffPolicy: FixedBackoffPolicy}, 5 * time.Second, 5 * time.Second},
+		{"exponential first", PersistedEvent{Attempts: 1, Backoff: 2 * time.Second, BackoffPolicy: ExponentialBackoffPolicy}, time.Second, 3 * time.Second},
+		{"exponential third", PersistedEvent{Attempts: 3, Backoff: 2 * time.Second, BackoffPolicy: ExponentialBackoffPolicy}, 4 * time.Second, 12 * time.Second},
+		{"exponential capped", PersistedEvent{Attempts: 30, Backoff: time.Second, BackoffPolicy: ExponentialBackoffPolicy}, 10 * time.Minute, 10 * time.Minute},
+		{"custom", PersistedEvent{Attempts: 3, Backoff: time.Second, BackoffPolicy: "linear"}, 3 * time.Second, 3 * time.Second},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			d := nextBackoff(&c.message)
+			assert.GreaterOrEqual(t, int64(d), int64(c.min))
+			assert.LessOrEqual(t, int64(d), int64(c.max))
+		})
+	}
+}
+
+func TestPersist_backoff(t *testing.T) {
+	var message PersistedEvent
+	Persist(events.Of(MockEvent{}), FixedBackoff(time.Minute)).Decorate(&message)
+	assert.Equal(t, FixedBackoffPolicy, message.BackoffPolicy)
+	assert.Equal(t, time.Minute, message.Backoff)
+}
+
+func TestInProcessDriver_Retry(t *testing.T) {
+	ctx := context.Background()
+	driver := NewInProcessDriver()
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{Attempts: 1, Backoff: time.Hour, BackoffPolicy: FixedBackoffPolicy}, 0))
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Retry(ctx, msg))
+	assert.Equal(t, 2, msg.Attempts)
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, int64(1), info.Delayed)
+}
@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/pkg/errors"
+)
+
+// BatchListener is a listener that handles persisted events in bulk. See QueueableDispatcher.SubscribeBatch.
+type BatchListener interface {
+	// Listen returns the event types to collect. Each type is batched separately.
+	Listen() []contract.Event
+	// ProcessBatch handles the events collected. If nil is returned, all events are acknowledged. To report the
+	// outcome of each event, return a BatchError. Any other error fails all events in the batch.
+	ProcessBatch(ctx context.Context, events []contract.Event) error
+}
+
+// BatchError reports the outcome of each event in a batch. It must be as long as the batch, and the error at each
+// index belongs to the event at the same index. Events with nil errors are acknowledged, while the others are
+// retried or failed, depending on their attempts.
+type BatchError []error
+
+// Error implements error.
+func (b BatchError) Error() string {
+	var messages []string
+	for i, err := range b {
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("#%d: %s", i, err))
+		}
+	}
+	return fmt.Sprintf("%d events in batch failed: %s", len(messages), strings.Join(messages, "; "))
+}
+
+// batcher collects the messages of the same type for a BatchListener.
+type batcher struct {
+	listener BatchListener
+	size     int
+	wait     time.Duration
+	in       chan *PersistedEvent
+}
+
+// SubscribeBatch subscribes a BatchListener. For each event type, up to size events are collected before calling
+// the listener. If the batch is not full, the listener is called with whatever collected after wait since the first
+// event has arrived. A worker waiting for the BatchListener can't consume other events.
+//
+// Every message in the batch is acknowledged, retried or failed on its own. The handler context is canceled when the
+// earliest HandleTimeout of the messages is reached, counting from when they were collected. Middlewares, Heartbeat
+// and the Limiter are not available to batch listeners.
+//
+// Batch listeners must be subscribed before Consume starts, otherwise SubscribeBatch panics.
+func (d *QueueableDispatcher) SubscribeBatch(listener BatchListener, size int, wait time.Duration) {
+	if size < 1 {
+		size = 1
+	}
+	d.learn(listener.Listen())
+	d.rwLock.Lock()
+	defer d.rwLock.Unlock()
+	if d.consuming {
+		panic("batch listeners must be subscribed before consuming")
+	}
+	if d.batchers == nil {
+		d.batchers = make(map[string]*batcher)
+	}
+	for _, e := range listener.Listen() {
+		d.batchers[e.Type()] = &batcher{listener: listener, size: size, wait: wait}
+	}
+}
+
+// runBatcher collects messages and hands them to the BatchListener, until the input is closed.
+func (d *QueueableDispatcher) runBatcher(jobCtx context.Context, b *batcher) {
+	var (
+		batch    []*PersistedEvent
+		deadline time.Time
+		timer    *time.Timer
+		timeout  <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		d.processBatch(jobCtx, b, batch, deadline)
+		batch, timer, timeout = nil, nil, nil
+	}
+	for {
+		select {
+		case msg, ok := <-b.in:
+			if !ok {
+				if len(batch) > 0 {
+					flush()
+				}
+				return
+			}
+			if len(batch) == 0 {
+				timer = time.NewTimer(b.wait)
+				timeout = timer.C
+				deadline = time.Now().Add(msg.HandleTimeout)
+			}
+			if due := time.Now().Add(msg.HandleTimeout); due.Before(deadline) {
+				deadline = due
+			}
+			batch = append(batch, msg)
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		}
+	}
+}
+
+// processBatch calls the BatchListener with the decoded events, and settles each message.
+func (d *QueueableDispatcher) processBatch(jobCtx context.Context, b *batcher, batch []*PersistedEvent, deadline time.Time) {
+	ctx, cancel := context.WithDeadline(jobCtx, deadline)
+	defer cancel()
+
+	var (
+		errs    = make([]error, len(batch))
+		evts    []contract.Event
+		indexes []int
+	)
+	start := time.Now()
+	for i, msg := range batch {
+		if msg.Attempts == 1 && !msg.DueAt.IsZero() {
+			observe(d.metrics.Latency, msg, start.Sub(msg.DueAt).Seconds())
+		}
+		d.setStatus(msg, StateRunning, msg.Attempts, nil, nil)
+		data, err := d.decode(msg)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		evts = append(evts, events.Of(data))
+		indexes = append(indexes, i)
+	}
+	if len(evts) > 0 {
+		err := callBatchListener(ctx, b.listener, evts)
+		var batchErr BatchError
+		switch {
+		case errors.As(err, &batchErr) && len(batchErr) == len(evts):
+			for j, i := range indexes {
+				errs[i] = batchErr[j]
+			}
+		case err != nil:
+			for _, i := range indexes {
+				errs[i] = err
+			}
+		}
+	}
+	for i, msg := range batch {
+		observe(d.metrics.Duration, msg, time.Since(start).Seconds())
+		d.settle(jobCtx, msg, errs[i], nil)
+	}
+}
+
+// callBatchListener calls the BatchListener, converting panics to PanicError.
+func callBatchListener(ctx context.Context, listener BatchListener, evts []contract.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return listener.ProcessBatch(ctx, evts)
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockBatchListener func(ctx context.Context, events []contract.Event) error
+
+func (m mockBatchListener) Listen() []contract.Event {
+	return events.From(MockEvent{})
+}
+
+func (m mockBatchListener) ProcessBatch(ctx context.Context, events []contract.Event) error {
+	return m(ctx, events)
+}
+
+func TestDispatcher_SubscribeBatch(t *testing.T) {
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseParallelism(2))
+	batches := make(chan []string, 10)
+	dispatcher.SubscribeBatch(mockBatchListener(func(ctx context.Context, evts []contract.Event) error {
+		var values []string
+		errs := make(BatchError, len(evts))
+		for i, e := range evts {
+			value := e.Data().(MockEvent).Value
+			values = append(values, value)
+			if value == "fail" {
+				errs[i] = errors.New("failed")
+			}
+		}
+		batches <- values
+		return errs
+	}), 3, 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, value := range []string{"foo", "bar", "fail"} {
+		assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: value}))))
+	}
+	go dispatcher.Consume(ctx)
+
+	// The batch is full.
+	assert.ElementsMatch(t, []string{"foo", "bar", "fail"}, <-batches)
+	assert.Eventually(t, func() bool {
+		info, _ := driver.Info(ctx)
+		return info.Failed == 1 && info.Waiting == 0
+	}, time.Second, time.Millisecond)
+
+	// The batch is flushed after waiting.
+	start := time.Now()
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "baz"}))))
+	assert.Equal(t, []string{"baz"}, <-batches)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestDispatcher_SubscribeBatch_error(t *testing.T) {
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseParallelism(1))
+	calls := make(chan int, 10)
+	dispatcher.SubscribeBatch(mockBatchListener(func(ctx context.Context, evts []contract.Event) error {
+		calls <- len(evts)
+		panic("boom")
+	}), 2, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}), MaxAttempts(2))))
+	}
+	go dispatcher.Consume(ctx)
+
+	assert.Equal(t, 2, <-calls)
+	assert.Equal(t, 2, <-calls)
+	assert.Eventually(t, func() bool {
+		info, _ := driver.Info(ctx)
+		return info.Failed == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestDispatcher_SubscribeBatch_consuming(t *testing.T) {
+	dispatcher := WithQueue(&events.SyncDispatcher{}, NewInProcessDriverWithPopInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Consume(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		dispatcher.rwLock.RLock()
+		defer dispatcher.rwLock.RUnlock()
+		return dispatcher.consuming
+	}, time.Second, time.Millisecond)
+	assert.Panics(t, func() {
+		dispatcher.SubscribeBatch(mockBatchListener(func(ctx context.Context, evts []contract.Event) error {
+			return nil
+		}), 1, time.Millisecond)
+	})
+
+	cancel()
+	<-done
+	assert.NotPanics(t, func() {
+		dispatcher.SubscribeBatch(mockBatchListener(func(ctx context.Context, evts []contract.Event) error {
+			return nil
+		}), 1, time.Millisecond)
+	})
+}
@@ -1,10 +1,66 @@
 package queue
 
+import "fmt"
+
 // ChannelConfig describes the key name of each queue, also known as channel.
+// Unique is not a channel, but the key of the index used to deduplicate messages
+// persisted with the Unique option. If empty, it is derived from Waiting.
+//
+// Lanes is the number of waiting lanes. Messages are put onto the lane matching
+// their Priority. The lowest lane uses the Waiting key, and lane n uses the key
+// Waiting suffixed by ":n". Zero means there is only one lane.
 type ChannelConfig struct {
 	Delayed  string
 	Failed   string
 	Reserved string
 	Waiting  string
 	Timeout  string
+	Unique   string
+	Lanes    int
+}
+
+// Lane returns the key name of the waiting lane for the given priority. The
+// priority is clamped to the available lanes.
+func (c ChannelConfig) Lane(priority int) string {
+	if priority >= c.Lanes {
+		priority = c.Lanes - 1
+	}
+	if priority <= 0 {
+		return c.Waiting
+	}
+	return fmt.Sprintf("%s:%d", c.Waiting, priority)
+}
+
+// The plain names of each channel. Drivers accept them wherever a channel is
+// expected, for example in Reload and Flush.
+const (
+	channelWaiting  = "waiting"
+	channelDelayed  = "delayed"
+	channelReserved = "reserved"
+	channelTimeout  = "timeout"
+	channelFailed   = "failed"
+)
+
+// defaultStarvationGuard is the default number of pops after which a lower
+// lane is polled first.
+const defaultStarvationGuard = 10
+
+// laneOrder returns the order in which waiting lanes are polled, starting from
+// the highest priority. Every guard pops, one of the lower lanes is polled first
+// in turn, so that they can still make progress under heavy load.
+func laneOrder(lanes int, pops uint64, guard int) []int {
+	if lanes < 1 {
+		lanes = 1
+	}
+	order := make([]int, 0, lanes)
+	if lanes > 1 && guard > 0 && pops%uint64(guard) == 0 {
+		order = append(order, int(pops/uint64(guard)%uint64(lanes-1)))
+	}
+	for n := lanes - 1; n >= 0; n-- {
+		if len(order) > 0 && order[0] == n {
+			continue
+		}
+		order = append(order, n)
+	}
+	return order
 }
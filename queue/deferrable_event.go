@@ -14,6 +14,12 @@ type DeferrablePersistentEvent struct {
 	handleTimeout time.Duration
 	maxAttempts   int
 	uniqueId      string
+	backoff       time.Duration
+	backoffPolicy string
+	uniquePolicy  string
+	priority      int
+	schemaVersion int
+	queue         string
 }
 
 // Defer defers the execution of the job for the period of time returned.
@@ -21,12 +27,22 @@ func (d DeferrablePersistentEvent) Defer() time.Duration {
 	return d.after
 }
 
+// Queue returns the name of the queue chosen by OnQueue. If empty, the event is routed by the routing table.
+func (d DeferrablePersistentEvent) Queue() string {
+	return d.queue
+}
+
 // Decorate decorates the PersistedEvent of this event by adding some meta info. it is called in the QueueableDispatcher,
 // after the Packer compresses the event.
 func (d DeferrablePersistentEvent) Decorate(s *PersistedEvent) {
 	s.UniqueId = d.uniqueId
 	s.HandleTimeout = d.handleTimeout
 	s.MaxAttempts = d.maxAttempts
+	s.Backoff = d.backoff
+	s.BackoffPolicy = d.backoffPolicy
+	s.UniquePolicy = d.uniquePolicy
+	s.Priority = d.priority
+	s.SchemaVersion = d.schemaVersion
 	s.Key = d.Type()
 }
 
@@ -78,6 +94,70 @@ func UniqueId(id string) PersistOption {
 	}
 }
 
+// Priority is a PersistOption that puts the DeferrablePersistentEvent onto a higher waiting lane, so that it is
+// consumed before events with a lower priority. The priority is capped by the number of lanes in the queue.
+// Not every driver supports priority. Those who don't will ignore this option.
+func Priority(priority int) PersistOption {
+	return func(event *DeferrablePersistentEvent) {
+		event.priority = priority
+	}
+}
+
+// SchemaVersion is a PersistOption that records the version of the event struct. When the struct evolves, bump the
+// version, and register an Upcaster to convert payloads of older versions still in the queue. See UseUpcaster.
+func SchemaVersion(version int) PersistOption {
+	return func(event *DeferrablePersistentEvent) {
+		event.schemaVersion = version
+	}
+}
+
+// OnQueue is a PersistOption that sends the DeferrablePersistentEvent to the named queue, overriding the routing
+// table. The dispatcher must be made by a DispatcherFactory that knows the queue. See UseRoutes.
+func OnQueue(name string) PersistOption {
+	return func(event *DeferrablePersistentEvent) {
+		event.queue = name
+	}
+}
+
+// The policies accepted by the Unique option.
+const (
+	// DropDuplicate drops the new message if one with the same UniqueId is still in the queue.
+	DropDuplicate = "drop"
+	// ReplaceDuplicate removes the old message with the same UniqueId and pushes the new one. If the old message is
+	// already reserved, it won't be stopped.
+	ReplaceDuplicate = "replace"
+)
+
+// Unique is a PersistOption that deduplicates DeferrablePersistentEvent by uniqueId. While a message with the same
+// uniqueId is still waiting, delayed or reserved, the new one is either dropped or replaces the old one, depending
+// on the policy given. See DropDuplicate and ReplaceDuplicate. Usually used together with the UniqueId option.
+// Not every driver supports deduplication. Those who don't will ignore this option.
+func Unique(policy string) PersistOption {
+	return func(event *DeferrablePersistentEvent) {
+		event.uniquePolicy = policy
+	}
+}
+
+// FixedBackoff is a PersistOption that retries the DeferrablePersistentEvent after the same duration each time.
+func FixedBackoff(duration time.Duration) PersistOption {
+	return Backoff(FixedBackoffPolicy, duration)
+}
+
+// ExponentialBackoff is a PersistOption that retries the DeferrablePersistentEvent after the duration given, and
+// doubles the duration for each subsequent retry.
+func ExponentialBackoff(duration time.Duration) PersistOption {
+	return Backoff(ExponentialBackoffPolicy, duration)
+}
+
+// Backoff is a PersistOption that computes the duration before each retry with the BackoffPolicy registered under
+// the given name. The duration given is recorded as PersistedEvent.Backoff. See RegisterBackoffPolicy.
+func Backoff(policy string, duration time.Duration) PersistOption {
+	return func(event *DeferrablePersistentEvent) {
+		event.backoffPolicy = policy
+		event.backoff = duration
+	}
+}
+
 func randomId() string {
 	const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	b := make([]byte, 16)
@@ -3,6 +3,8 @@ package queue
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"github.com/DoNewsCode/core/otgorm"
 	"github.com/DoNewsCode/core/otredis"
 	"runtime"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/go-kit/kit/metrics"
 	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
 )
 
 /*
@@ -24,10 +27,14 @@ DispatcherMaker, the Dispatcher and the exported configs.
 		contract.Dispatcher
 		Driver        `optional:"true"`
 		otredis.Maker `optional:"true"`
+		otgorm.Maker  `optional:"true"`
 		log.Logger
 		contract.AppName
 		contract.Env
 		Gauge `optional:"true"`
+		opentracing.Tracer `optional:"true"`
+		*Metrics `optional:"true"`
+		Middleware `optional:"true"`
 	Provides:
 		DispatcherMaker
 		DispatcherFactory
@@ -82,9 +89,29 @@ type DispatcherMaker interface {
 }
 
 type configuration struct {
-	RedisName                      string `yaml:"redisName" json:"redisName"`
-	Parallelism                    int    `yaml:"parallelism" json:"parallelism"`
-	CheckQueueLengthIntervalSecond int    `yaml:"checkQueueLengthIntervalSecond" json:"checkQueueLengthIntervalSecond"`
+	Driver                         string               `yaml:"driver" json:"driver"`
+	RedisName                      string               `yaml:"redisName" json:"redisName"`
+	GormName                       string               `yaml:"gormName" json:"gormName"`
+	Path                           string               `yaml:"path" json:"path"`
+	Packer                         string               `yaml:"packer" json:"packer"`
+	Lanes                          int                  `yaml:"lanes" json:"lanes"`
+	Parallelism                    int                  `yaml:"parallelism" json:"parallelism"`
+	MaxParallelism                 int                  `yaml:"maxParallelism" json:"maxParallelism"`
+	ScaleIntervalSecond            int                  `yaml:"scaleIntervalSecond" json:"scaleIntervalSecond"`
+	CheckQueueLengthIntervalSecond int                  `yaml:"checkQueueLengthIntervalSecond" json:"checkQueueLengthIntervalSecond"`
+	GracePeriodSecond              int                  `yaml:"gracePeriodSecond" json:"gracePeriodSecond"`
+	Limiter                        string               `yaml:"limiter" json:"limiter"`
+	Limits                         []limitConfiguration `yaml:"limits" json:"limits"`
+	StatusStore                    string               `yaml:"statusStore" json:"statusStore"`
+	StatusTTLSecond                int                  `yaml:"statusTTLSecond" json:"statusTTLSecond"`
+	Routes                         []string             `yaml:"routes" json:"routes"`
+}
+
+type limitConfiguration struct {
+	Event       string  `yaml:"event" json:"event"`
+	Concurrency int     `yaml:"concurrency" json:"concurrency"`
+	Rate        float64 `yaml:"rate" json:"rate"`
+	Burst       int     `yaml:"burst" json:"burst"`
 }
 
 // makerIn is the injection parameters for provideDispatcherFactory
@@ -95,10 +122,14 @@ type makerIn struct {
 	Dispatcher contract.Dispatcher
 	Driver     Driver        `optional:"true"`
 	RedisMaker otredis.Maker `optional:"true"`
+	GormMaker  otgorm.Maker  `optional:"true"`
 	Logger     log.Logger
 	AppName    contract.AppName
 	Env        contract.Env
-	Gauge      Gauge `optional:"true"`
+	Gauge      Gauge              `optional:"true"`
+	Tracer     opentracing.Tracer `optional:"true"`
+	Metrics    *Metrics           `optional:"true"`
+	Middleware Middleware         `optional:"true"`
 }
 
 // makerOut is the di output of provideDispatcherFactory
@@ -107,11 +138,26 @@ type makerOut struct {
 
 	DispatcherMaker   DispatcherMaker
 	DispatcherFactory DispatcherFactory
+	GormConnections   []string                `name:"queueGormConnections"`
+	StatusConnections []string                `name:"queueStatusGormConnections"`
 	ExportedConfig    []config.ExportedConfig `group:"config,flatten"`
 }
 
 func (d makerOut) ModuleSentinel() {}
 
+// ProvideMigration implements otgorm.MigrationProvider. It creates the tables
+// for queues backed by GormDriver or GormStatusStore.
+func (d makerOut) ProvideMigration() []*otgorm.Migration {
+	var migrations []*otgorm.Migration
+	for _, conn := range d.GormConnections {
+		migrations = append(migrations, GormMigrations(conn)...)
+	}
+	for _, conn := range d.StatusConnections {
+		migrations = append(migrations, GormStatusMigrations(conn)...)
+	}
+	return migrations
+}
+
 // provideDispatcherFactory is a provider for *DispatcherFactory and *QueueableDispatcher.
 // It also provides an interface for each.
 func provideDispatcherFactory(p makerIn) (makerOut, error) {
@@ -123,6 +169,11 @@ func provideDispatcherFactory(p makerIn) (makerOut, error) {
 	if err != nil {
 		level.Warn(p.Logger).Log("err", err)
 	}
+	routes, err := routingTable(queueConfs)
+	if err != nil {
+		return makerOut{}, err
+	}
+	var dispatcherFactory DispatcherFactory
 	factory := di.NewFactory(func(name string) (di.Pair, error) {
 		var (
 			ok   bool
@@ -139,55 +190,297 @@ func provideDispatcherFactory(p makerIn) (makerOut, error) {
 		if p.Gauge != nil {
 			p.Gauge = p.Gauge.With("queue", name)
 		}
+		p.Metrics = p.Metrics.with("queue", name)
 
+		// Drivers created here are owned by the queue, and closed along with it.
+		var closer func()
 		if p.Driver == nil {
-			if p.RedisMaker == nil {
-				return di.Pair{}, fmt.Errorf("default redis client not found, please provide it or provide a queue.Driver")
-			}
-			if conf.RedisName == "" {
-				conf.RedisName = "default"
-			}
-			redisClient, err := p.RedisMaker.Make(conf.RedisName)
+			driver, err := newDriver(p, name, conf)
 			if err != nil {
-				return di.Pair{}, fmt.Errorf("failed to initiate redis driver: %w", err)
+				return di.Pair{}, err
 			}
-			p.Driver = &RedisDriver{
-				Logger:      p.Logger,
-				RedisClient: redisClient,
-				ChannelConfig: ChannelConfig{
-					Delayed:  fmt.Sprintf("{%s:%s:%s}:delayed", p.AppName.String(), p.Env.String(), name),
-					Failed:   fmt.Sprintf("{%s:%s:%s}:failed", p.AppName.String(), p.Env.String(), name),
-					Reserved: fmt.Sprintf("{%s:%s:%s}:reserved", p.AppName.String(), p.Env.String(), name),
-					Waiting:  fmt.Sprintf("{%s:%s:%s}:waiting", p.AppName.String(), p.Env.String(), name),
-					Timeout:  fmt.Sprintf("{%s:%s:%s}:timeout", p.AppName.String(), p.Env.String(), name),
-				},
+			p.Driver = driver
+			if c, ok := driver.(interface{ Close() error }); ok {
+				closer = func() { _ = c.Close() }
 			}
 		}
-		queuedDispatcher := WithQueue(
-			p.Dispatcher,
-			p.Driver,
+		limiter, err := newLimiter(p, name, conf)
+		if err != nil {
+			return di.Pair{}, err
+		}
+		payloadPacker, err := newPacker(conf)
+		if err != nil {
+			return di.Pair{}, err
+		}
+		statusStore, err := newStatusStore(p, name, conf)
+		if err != nil {
+			return di.Pair{}, err
+		}
+		opts := []func(*QueueableDispatcher){
 			UseLogger(p.Logger),
 			UseParallelism(conf.Parallelism),
 			UseGauge(p.Gauge, time.Duration(conf.CheckQueueLengthIntervalSecond)*time.Second),
-		)
+			UseLimiter(limiter),
+			UseGracePeriod(time.Duration(conf.GracePeriodSecond) * time.Second),
+			UseMetrics(p.Metrics),
+			UsePacker(payloadPacker),
+		}
+		if conf.MaxParallelism > conf.Parallelism {
+			interval := time.Duration(conf.ScaleIntervalSecond) * time.Second
+			opts = append(opts, UseAdaptiveParallelism(conf.Parallelism, conf.MaxParallelism, interval))
+		}
+		if p.Middleware != nil {
+			opts = append(opts, UseMiddleware(p.Middleware))
+		}
+		if p.Tracer != nil {
+			opts = append(opts, UseTracer(p.Tracer))
+		}
+		if statusStore != nil {
+			opts = append(opts, UseStatusStore(statusStore))
+		}
+		if len(routes) > 0 {
+			opts = append(opts, UseRoutes(dispatcherFactory, routes))
+		}
+		queuedDispatcher := WithQueue(p.Dispatcher, p.Driver, opts...)
 		return di.Pair{
-			Closer: nil,
+			Closer: closer,
 			Conn:   queuedDispatcher,
 		}, nil
 	})
 
+	dispatcherFactory = DispatcherFactory{Factory: factory}
+
 	// QueueableDispatcher must be created eagerly, so that the consumer goroutines can start on boot up.
 	for name := range queueConfs {
 		factory.Make(name)
 	}
 
-	dispatcherFactory := DispatcherFactory{Factory: factory}
 	return makerOut{
 		DispatcherFactory: dispatcherFactory,
 		DispatcherMaker:   dispatcherFactory,
+		GormConnections:   gormConnections(queueConfs, func(conf configuration) bool { return conf.Driver == "gorm" }),
+		StatusConnections: gormConnections(queueConfs, func(conf configuration) bool { return conf.StatusStore == "gorm" }),
 	}, nil
 }
 
+// newDriver creates the Driver selected by the queue configuration.
+func newDriver(p makerIn, name string, conf configuration) (Driver, error) {
+	switch conf.Driver {
+	case "", "redis":
+		if p.RedisMaker == nil {
+			return nil, fmt.Errorf("default redis client not found, please provide it or provide a queue.Driver")
+		}
+		if conf.RedisName == "" {
+			conf.RedisName = "default"
+		}
+		redisClient, err := p.RedisMaker.Make(conf.RedisName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate redis driver: %w", err)
+		}
+		return &RedisDriver{
+			Logger:      p.Logger,
+			RedisClient: redisClient,
+			Packer:      newDriverPacker(conf),
+			ChannelConfig: ChannelConfig{
+				Delayed:  fmt.Sprintf("{%s:%s:%s}:delayed", p.AppName.String(), p.Env.String(), name),
+				Failed:   fmt.Sprintf("{%s:%s:%s}:failed", p.AppName.String(), p.Env.String(), name),
+				Reserved: fmt.Sprintf("{%s:%s:%s}:reserved", p.AppName.String(), p.Env.String(), name),
+				Waiting:  fmt.Sprintf("{%s:%s:%s}:waiting", p.AppName.String(), p.Env.String(), name),
+				Timeout:  fmt.Sprintf("{%s:%s:%s}:timeout", p.AppName.String(), p.Env.String(), name),
+				Unique:   fmt.Sprintf("{%s:%s:%s}:unique", p.AppName.String(), p.Env.String(), name),
+				Lanes:    conf.Lanes,
+			},
+		}, nil
+	case "redisStream":
+		if p.RedisMaker == nil {
+			return nil, fmt.Errorf("default redis client not found, please provide it or provide a queue.Driver")
+		}
+		if conf.RedisName == "" {
+			conf.RedisName = "default"
+		}
+		redisClient, err := p.RedisMaker.Make(conf.RedisName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate redis stream driver: %w", err)
+		}
+		return &RedisStreamDriver{
+			Logger:      p.Logger,
+			RedisClient: redisClient,
+			Packer:      newDriverPacker(conf),
+			ChannelConfig: ChannelConfig{
+				Delayed: fmt.Sprintf("{%s:%s:%s}:delayed", p.AppName.String(), p.Env.String(), name),
+				Failed:  fmt.Sprintf("{%s:%s:%s}:failed", p.AppName.String(), p.Env.String(), name),
+				Waiting: fmt.Sprintf("{%s:%s:%s}:stream", p.AppName.String(), p.Env.String(), name),
+				Timeout: fmt.Sprintf("{%s:%s:%s}:timeout", p.AppName.String(), p.Env.String(), name),
+			},
+		}, nil
+	case "gorm":
+		if p.GormMaker == nil {
+			return nil, fmt.Errorf("gorm maker not found, please provide it or provide a queue.Driver")
+		}
+		if conf.GormName == "" {
+			conf.GormName = "default"
+		}
+		db, err := p.GormMaker.Make(conf.GormName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate gorm driver: %w", err)
+		}
+		return &GormDriver{
+			Logger: p.Logger,
+			DB:     db,
+			Queue:  fmt.Sprintf("%s:%s:%s", p.AppName.String(), p.Env.String(), name),
+			Packer: newDriverPacker(conf),
+		}, nil
+	case "file":
+		if conf.Path == "" {
+			conf.Path = filepath.Join("data", "queue", name+".log")
+		}
+		return &FileDriver{
+			Logger: p.Logger,
+			Path:   conf.Path,
+			Packer: newDriverPacker(conf),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown queue driver %s", conf.Driver)
+	}
+}
+
+// newPacker creates the Packer for event payloads selected by the queue configuration.
+func newPacker(conf configuration) (Packer, error) {
+	switch conf.Packer {
+	case "", "gob":
+		return packer{}, nil
+	case "json":
+		return JSONPacker{}, nil
+	case "protobuf":
+		return ProtobufPacker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown queue packer %s", conf.Packer)
+	}
+}
+
+// newDriverPacker returns the Packer for drivers. Unless the gob packer is selected, messages are saved in
+// envelopes, so that they can be read by other languages.
+func newDriverPacker(conf configuration) Packer {
+	switch conf.Packer {
+	case "", "gob":
+		return nil
+	default:
+		return EnvelopePacker{}
+	}
+}
+
+// newLimiter creates the Limiter selected by the queue configuration. It returns nil if there is no limit.
+func newLimiter(p makerIn, name string, conf configuration) (Limiter, error) {
+	if len(conf.Limits) == 0 {
+		return nil, nil
+	}
+	limits := make(map[string]Limit, len(conf.Limits))
+	for _, l := range conf.Limits {
+		limits[l.Event] = Limit{Concurrency: l.Concurrency, Rate: l.Rate, Burst: l.Burst}
+	}
+	switch conf.Limiter {
+	case "", "local":
+		return &LocalLimiter{Limits: limits}, nil
+	case "redis":
+		if p.RedisMaker == nil {
+			return nil, fmt.Errorf("default redis client not found, please provide it or use the local limiter")
+		}
+		if conf.RedisName == "" {
+			conf.RedisName = "default"
+		}
+		redisClient, err := p.RedisMaker.Make(conf.RedisName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate redis limiter: %w", err)
+		}
+		return &RedisLimiter{
+			RedisClient: redisClient,
+			Prefix:      fmt.Sprintf("{%s:%s:%s}:limit", p.AppName.String(), p.Env.String(), name),
+			Limits:      limits,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown queue limiter %s", conf.Limiter)
+	}
+}
+
+// newStatusStore creates the StatusStore selected by the queue configuration. It returns nil if job status is not
+// tracked.
+func newStatusStore(p makerIn, name string, conf configuration) (StatusStore, error) {
+	switch conf.StatusStore {
+	case "":
+		return nil, nil
+	case "redis":
+		if p.RedisMaker == nil {
+			return nil, fmt.Errorf("default redis client not found, please provide it or use the gorm status store")
+		}
+		if conf.RedisName == "" {
+			conf.RedisName = "default"
+		}
+		redisClient, err := p.RedisMaker.Make(conf.RedisName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate redis status store: %w", err)
+		}
+		return &RedisStatusStore{
+			RedisClient: redisClient,
+			Prefix:      fmt.Sprintf("{%s:%s:%s}:status", p.AppName.String(), p.Env.String(), name),
+			TTL:         time.Duration(conf.StatusTTLSecond) * time.Second,
+		}, nil
+	case "gorm":
+		if p.GormMaker == nil {
+			return nil, fmt.Errorf("gorm maker not found, please provide it or use the redis status store")
+		}
+		if conf.GormName == "" {
+			conf.GormName = "default"
+		}
+		db, err := p.GormMaker.Make(conf.GormName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initiate gorm status store: %w", err)
+		}
+		return &GormStatusStore{
+			DB:    db,
+			Queue: fmt.Sprintf("%s:%s:%s", p.AppName.String(), p.Env.String(), name),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown queue status store %s", conf.StatusStore)
+	}
+}
+
+// routingTable maps the event types and packages listed in the routes of each queue to the queue name.
+func routingTable(queueConfs map[string]configuration) (map[string]string, error) {
+	routes := make(map[string]string)
+	for name, conf := range queueConfs {
+		for _, route := range conf.Routes {
+			if existing, ok := routes[route]; ok {
+				return nil, fmt.Errorf("%s is routed to both queue %s and %s", route, existing, name)
+			}
+			routes[route] = name
+		}
+	}
+	return routes, nil
+}
+
+// gormConnections returns the distinct gorm connections used by the queues selected.
+func gormConnections(queueConfs map[string]configuration, selected func(conf configuration) bool) []string {
+	var (
+		connections []string
+		seen        = make(map[string]struct{})
+	)
+	for _, conf := range queueConfs {
+		if !selected(conf) {
+			continue
+		}
+		conn := conf.GormName
+		if conn == "" {
+			conn = "default"
+		}
+		if _, ok := seen[conn]; ok {
+			continue
+		}
+		seen[conn] = struct{}{}
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
 // ProvideRunGroup implements container.RunProvider.
 func (d makerOut) ProvideRunGroup(group *run.Group) {
 	for name := range d.DispatcherFactory.List() {
@@ -232,9 +525,11 @@ func provideConfig() configOut {
 		Data: map[string]interface{}{
 			"queue": map[string]configuration{
 				"default": {
+					Driver:                         "redis",
 					RedisName:                      "default",
 					Parallelism:                    runtime.NumCPU(),
 					CheckQueueLengthIntervalSecond: 15,
+					GracePeriodSecond:              10,
 				},
 			},
 		},
@@ -8,7 +8,11 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -23,14 +27,14 @@ func TestProvideDispatcher(t *testing.T) {
 	out, err := provideDispatcherFactory(makerIn{
 		Conf: config.MapAdapter{"queue": map[string]configuration{
 			"default": {
-				"default",
-				1,
-				5,
+				RedisName:                      "default",
+				Parallelism:                    1,
+				CheckQueueLengthIntervalSecond: 5,
 			},
 			"alternative": {
-				"default",
-				3,
-				5,
+				RedisName:                      "default",
+				Parallelism:                    3,
+				CheckQueueLengthIntervalSecond: 5,
 			},
 		}},
 		Dispatcher: &events.SyncDispatcher{},
@@ -87,14 +91,14 @@ func TestProvideDispatcher_withDriver(t *testing.T) {
 	out, err := provideDispatcherFactory(makerIn{
 		Conf: config.MapAdapter{"queue": map[string]configuration{
 			"default": {
-				"default",
-				1,
-				5,
+				RedisName:                      "default",
+				Parallelism:                    1,
+				CheckQueueLengthIntervalSecond: 5,
 			},
 			"alternative": {
-				"default",
-				3,
-				5,
+				RedisName:                      "default",
+				Parallelism:                    3,
+				CheckQueueLengthIntervalSecond: 5,
 			},
 		}},
 		Dispatcher: &events.SyncDispatcher{},
@@ -112,7 +116,159 @@ func TestProvideDispatcher_withDriver(t *testing.T) {
 	assert.Implements(t, (*di.Module)(nil), out)
 }
 
+type gormMaker struct{}
+
+func (m gormMaker) Make(name string) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+}
+
+func TestProvideDispatcher_withGorm(t *testing.T) {
+	out, err := provideDispatcherFactory(makerIn{
+		Conf: config.MapAdapter{"queue": map[string]configuration{
+			"default": {
+				Driver:      "gorm",
+				GormName:    "default",
+				Parallelism: 1,
+			},
+			"alternative": {
+				Driver:      "gorm",
+				Parallelism: 1,
+			},
+		}},
+		Dispatcher: &events.SyncDispatcher{},
+		GormMaker:  gormMaker{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+	})
+	assert.NoError(t, err)
+	def, err := out.DispatcherMaker.Make("default")
+	assert.NoError(t, err)
+	assert.IsType(t, &GormDriver{}, def.Driver())
+	assert.Equal(t, []string{"default"}, out.GormConnections)
+	assert.Len(t, out.ProvideMigration(), 1)
+}
+
 func TestProvideConfigs(t *testing.T) {
 	c := provideConfig()
 	assert.NotEmpty(t, c.Config)
 }
+
+func TestProvideDispatcher_withLimits(t *testing.T) {
+	out, err := provideDispatcherFactory(makerIn{
+		Conf: config.MapAdapter{"queue": map[string]configuration{
+			"default": {
+				Parallelism: 1,
+				Limits:      []limitConfiguration{{Event: "foo", Concurrency: 1, Rate: 5}},
+			},
+			"alternative": {
+				Parallelism: 1,
+				Limiter:     "redis",
+				Limits:      []limitConfiguration{{Event: "foo", Concurrency: 1}},
+			},
+		}},
+		Dispatcher: &events.SyncDispatcher{},
+		Driver:     mockDriver{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+	})
+	assert.NoError(t, err)
+	def, err := out.DispatcherMaker.Make("default")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Limit{"foo": {Concurrency: 1, Rate: 5}}, def.limiter.(*LocalLimiter).Limits)
+
+	_, err = out.DispatcherMaker.Make("alternative")
+	assert.Error(t, err, "redis limiter requires otredis.Maker")
+}
+
+func TestProvideDispatcher_withMiddleware(t *testing.T) {
+	out, err := provideDispatcherFactory(makerIn{
+		Conf: config.MapAdapter{"queue": map[string]configuration{
+			"default": {Parallelism: 1},
+		}},
+		Dispatcher: &events.SyncDispatcher{},
+		Driver:     mockDriver{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+		Middleware: func(next Handler) Handler { return next },
+	})
+	assert.NoError(t, err)
+	def, err := out.DispatcherMaker.Make("default")
+	assert.NoError(t, err)
+	assert.Len(t, def.middlewares, 1)
+}
+
+func TestProvideDispatcher_withPacker(t *testing.T) {
+	out, err := provideDispatcherFactory(makerIn{
+		Conf: config.MapAdapter{"queue": map[string]configuration{
+			"default":     {Driver: "gorm", Packer: "json", Parallelism: 1},
+			"alternative": {Driver: "gorm", Packer: "xml", Parallelism: 1},
+		}},
+		Dispatcher: &events.SyncDispatcher{},
+		GormMaker:  gormMaker{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+	})
+	assert.NoError(t, err)
+	def, err := out.DispatcherMaker.Make("default")
+	assert.NoError(t, err)
+	assert.Equal(t, JSONPacker{}, def.packer)
+	assert.Equal(t, EnvelopePacker{}, def.Driver().(*GormDriver).Packer)
+
+	_, err = out.DispatcherMaker.Make("alternative")
+	assert.Error(t, err)
+}
+
+func TestProvideDispatcher_withRoutes(t *testing.T) {
+	conf := map[string]configuration{
+		"default": {Driver: "gorm", Routes: []string{"github.com/foo/bar"}},
+		"emails":  {Driver: "gorm", Routes: []string{"github.com/foo/mail.SendEvent", "github.com/foo/mail"}},
+	}
+	out, err := provideDispatcherFactory(makerIn{
+		Conf:       config.MapAdapter{"queue": conf},
+		Dispatcher: &events.SyncDispatcher{},
+		GormMaker:  gormMaker{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+	})
+	assert.NoError(t, err)
+	def, _ := out.DispatcherMaker.Make("default")
+	assert.Equal(t, "emails", def.routes["github.com/foo/mail"])
+	assert.Equal(t, "default", def.routes["github.com/foo/bar"])
+
+	conf["default"] = configuration{Driver: "gorm", Routes: []string{"github.com/foo/mail"}}
+	_, err = provideDispatcherFactory(makerIn{
+		Conf:       config.MapAdapter{"queue": conf},
+		Dispatcher: &events.SyncDispatcher{},
+		GormMaker:  gormMaker{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+	})
+	assert.Error(t, err)
+}
+
+func TestProvideDispatcher_withFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+	out, err := provideDispatcherFactory(makerIn{
+		Conf: config.MapAdapter{"queue": map[string]configuration{
+			"default": {Driver: "file", Path: filepath.Join(dir, "default.log")},
+		}},
+		Dispatcher: &events.SyncDispatcher{},
+		Logger:     log.NewNopLogger(),
+		AppName:    config.AppName("test"),
+		Env:        config.EnvTesting,
+	})
+	assert.NoError(t, err)
+	def, _ := out.DispatcherMaker.Make("default")
+	assert.IsType(t, &FileDriver{}, def.Driver())
+	assert.NoError(t, def.Dispatch(context.Background(), Persist(events.Of(MockEvent{}))))
+	out.DispatcherFactory.Close()
+	_, err = os.Stat(filepath.Join(dir, "default.log"))
+	assert.NoError(t, err)
+}
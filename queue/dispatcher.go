@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -14,6 +15,8 @@ import (
 
 	"github.com/DoNewsCode/core/contract"
 	"github.com/DoNewsCode/core/events"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/pkg/errors"
 )
 
@@ -34,48 +37,91 @@ type QueueableDispatcher struct {
 	parallelism              int
 	queueLengthGauge         metrics.Gauge
 	checkQueueLengthInterval time.Duration
+	limiter                  Limiter
+	gracePeriod              time.Duration
+	tracer                   opentracing.Tracer
+	metrics                  *Metrics
+	middlewares              []Middleware
+	listenerMiddlewares      []events.Middleware
+	handler                  Handler
+	upcasters                map[string]Upcaster
+	statusStore              StatusStore
+	batchers                 map[string]*batcher
+	consuming                bool
+	routes                   map[string]string
+	factory                  DispatcherFactory
+	scaler                   *scaler
 }
 
 // Dispatch dispatches an event. See contract.Dispatcher.
 func (d *QueueableDispatcher) Dispatch(ctx context.Context, e contract.Event) error {
-	if _, ok := e.(*PersistedEvent); ok {
-		rType := d.reflectType(e.Type())
-		if rType == nil {
-			return fmt.Errorf("unable to reverse engineer the event %s", e.Type())
-		}
-		ptr := reflect.New(rType)
-		err := d.packer.Unmarshal(e.Data().([]byte), ptr)
+	if msg, ok := e.(*PersistedEvent); ok {
+		data, err := d.decode(msg)
 		if err != nil {
-			return errors.Wrapf(err, "dispatch serialized %s failed", e.Type())
+			return err
 		}
-		return d.base.Dispatch(ctx, events.Of(ptr.Elem().Interface()))
+		return d.base.Dispatch(ctx, events.Of(data))
 	}
 	if _, ok := e.(persistent); ok {
-		data, err := d.packer.Marshal(e.Data())
+		target, err := d.route(e)
 		if err != nil {
 			return errors.Wrapf(err, "dispatch deferrable %s failed", e.Type())
 		}
-		msg := &PersistedEvent{
-			Attempts: 1,
-			Value:    data,
-		}
-		e.(persistent).Decorate(msg)
-		return d.driver.Push(ctx, msg, e.(persistent).Defer())
+		return target.push(ctx, e)
 	}
 	return d.base.Dispatch(ctx, e)
 }
 
+// push saves the persistent event in the driver.
+func (d *QueueableDispatcher) push(ctx context.Context, e contract.Event) error {
+	data, err := d.packer.Marshal(e.Data())
+	if err != nil {
+		return errors.Wrapf(err, "dispatch deferrable %s failed", e.Type())
+	}
+	msg := &PersistedEvent{
+		Attempts:    1,
+		Value:       data,
+		ContentType: contentTypeOf(d.packer),
+	}
+	e.(persistent).Decorate(msg)
+	inject(ctx, msg)
+	msg.DueAt = time.Now().Add(e.(persistent).Defer())
+
+	// The status is recorded once the message is accepted, but stamped with the time before pushing. A fast
+	// consumer may have updated it in the meantime, and StatusStore keeps the most recent one.
+	queuedAt := time.Now()
+	err = d.driver.Push(ctx, msg, e.(persistent).Defer())
+	if errors.Is(err, ErrDuplicate) {
+		_ = level.Debug(d.logger).Log("msg", fmt.Sprintf("event %s is dropped as a duplicate", msg.UniqueId))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.setStatusAt(queuedAt, msg, StateQueued, 0, nil, nil)
+	return nil
+}
+
 // Subscribe subscribes an event. See contract.Dispatcher.
 func (d *QueueableDispatcher) Subscribe(listener contract.Listener) {
-	d.rwLock.Lock()
-	for _, e := range listener.Listen() {
-		d.reflectTypes[e.Type()] = reflect.TypeOf(e.Data())
+	d.learn(listener.Listen())
+	d.base.Subscribe(events.Wrap(listener, d.listenerMiddlewares...))
+}
+
+// SubscribeWithHandle subscribes an event, and returns a Subscription to unsubscribe it later. The base dispatcher
+// must implement events.HandleSubscriber, otherwise it panics. Events already persisted are kept in the queue after
+// unsubscribing, and can still be consumed by other listeners of the same type.
+func (d *QueueableDispatcher) SubscribeWithHandle(listener contract.Listener) *events.Subscription {
+	base, ok := d.base.(events.HandleSubscriber)
+	if !ok {
+		panic(fmt.Sprintf("the base dispatcher %T doesn't support unsubscribing", d.base))
 	}
-	d.rwLock.Unlock()
-	d.base.Subscribe(listener)
+	d.learn(listener.Listen())
+	return base.SubscribeWithHandle(events.Wrap(listener, d.listenerMiddlewares...))
 }
 
-// Consume starts the runner and blocks until context canceled or error occurred.
+// Consume starts the runner and blocks until context canceled or error occurred. Once the context is canceled,
+// no more messages are popped, and the running handlers are given a grace period to finish. See UseGracePeriod.
 func (d *QueueableDispatcher) Consume(ctx context.Context) error {
 	if d.logger == nil {
 		d.logger = log.NewNopLogger()
@@ -83,6 +129,24 @@ func (d *QueueableDispatcher) Consume(ctx context.Context) error {
 	var jobChan = make(chan *PersistedEvent)
 	g, ctx := errgroup.WithContext(ctx)
 
+	// The batchers are fixed once consuming. See SubscribeBatch.
+	d.rwLock.Lock()
+	d.consuming = true
+	batchers := make(map[string]*batcher, len(d.batchers))
+	for key, b := range d.batchers {
+		batchers[key] = b
+	}
+	d.rwLock.Unlock()
+	defer func() {
+		d.rwLock.Lock()
+		d.consuming = false
+		d.rwLock.Unlock()
+	}()
+
+	// Handlers are not canceled along with ctx, but after the grace period.
+	jobCtx, cancelJobs := context.WithCancel(detachedContext{ctx})
+	defer cancelJobs()
+
 	g.Go(func() error {
 		defer close(jobChan)
 		for {
@@ -93,7 +157,12 @@ func (d *QueueableDispatcher) Consume(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
-			jobChan <- msg
+			select {
+			case jobChan <- msg:
+			case <-ctx.Done():
+				d.release(msg)
+				return ctx.Err()
+			}
 		}
 	})
 
@@ -114,40 +183,308 @@ func (d *QueueableDispatcher) Consume(ctx context.Context) error {
 			}
 		})
 	}
+	// Batchers are counted as workers, but they can only stop after the workers have handed over all messages.
+	var workers, feeders sync.WaitGroup
+	for _, b := range batchers {
+		b := b
+		b.in = make(chan *PersistedEvent)
+		workers.Add(1)
+		g.Go(func() error {
+			defer workers.Done()
+			d.runBatcher(jobCtx, b)
+			return nil
+		})
+	}
+	// Each worker can be stopped by closing its quit channel. Only the scaler does so.
+	var quits []chan struct{}
+	spawn := func() {
+		quit := make(chan struct{})
+		quits = append(quits, quit)
+		workers.Add(1)
+		feeders.Add(1)
+		g.Go(func() error {
+			defer workers.Done()
+			defer feeders.Done()
+			for {
+				select {
+				case msg, ok := <-jobChan:
+					if !ok {
+						return nil
+					}
+					if b, ok := batchers[msg.Key]; ok {
+						b.in <- msg
+						continue
+					}
+					d.work(jobCtx, msg)
+				case <-quit:
+					return nil
+				}
+			}
+		})
+	}
 	for i := 0; i < d.parallelism; i++ {
+		spawn()
+	}
+	if d.scaler != nil {
+		// The scaler is counted as a worker too, so that it can't spawn workers after they are all gone.
+		workers.Add(1)
+		feeders.Add(1)
 		g.Go(func() error {
-			for msg := range jobChan {
-				d.work(ctx, msg)
+			defer workers.Done()
+			defer feeders.Done()
+			ticker := time.NewTicker(d.scaler.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					info, err := d.driver.Info(ctx)
+					if err != nil {
+						_ = level.Warn(d.logger).Log("err", errors.Wrap(err, "failed to scale the workers"))
+						continue
+					}
+					n := d.scaler.desired(len(quits), info.Waiting)
+					for len(quits) < n {
+						spawn()
+					}
+					for len(quits) > n {
+						close(quits[len(quits)-1])
+						quits = quits[:len(quits)-1]
+					}
+					d.scaler.setWorkers(n)
+				case <-ctx.Done():
+					return nil
+				}
 			}
-			return nil
 		})
 	}
+	g.Go(func() error {
+		feeders.Wait()
+		for _, b := range batchers {
+			close(b.in)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		<-ctx.Done()
+		d.drain(&workers, cancelJobs)
+		return nil
+	})
 	return g.Wait()
 }
 
+// detachedContext carries the values of the parent context, but not its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// drain waits for the running handlers until the grace period is over, and then cancels them.
+func (d *QueueableDispatcher) drain(workers *sync.WaitGroup, cancelJobs func()) {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(d.gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		_ = level.Info(d.logger).Log("msg", "grace period is over, canceling the running handlers")
+		cancelJobs()
+	}
+}
+
+// release returns the message to the waiting channel, if the driver supports it. Otherwise, the message will be
+// moved to the timeout channel once its HandleTimeout is reached.
+func (d *QueueableDispatcher) release(msg *PersistedEvent) {
+	releaser, ok := d.driver.(Releaser)
+	if !ok {
+		return
+	}
+	if err := releaser.Release(context.Background(), msg); err != nil {
+		_ = level.Warn(d.logger).Log("err", errors.Wrapf(err, "failed to release event %s", msg.Key))
+		return
+	}
+	d.setStatus(msg, StateQueued, msg.Attempts-1, nil, nil)
+}
+
+// Status returns the Status of the persisted event with the given UniqueId. If the dispatcher has no StatusStore,
+// ErrNoStatus is returned. See UseStatusStore.
+func (d *QueueableDispatcher) Status(ctx context.Context, uniqueId string) (Status, error) {
+	if d.statusStore == nil {
+		return Status{}, ErrNoStatus
+	}
+	return d.statusStore.Get(ctx, uniqueId)
+}
+
+// setStatus records the state of the message, if the dispatcher has a StatusStore. Failing to do so doesn't affect
+// the handling of the message, so the error is only logged.
+func (d *QueueableDispatcher) setStatus(msg *PersistedEvent, state string, attempts int, err error, result json.RawMessage) {
+	d.setStatusAt(time.Now(), msg, state, attempts, err, result)
+}
+
+// setStatusAt records the state of the message as of the given time. See setStatus.
+func (d *QueueableDispatcher) setStatusAt(at time.Time, msg *PersistedEvent, state string, attempts int, err error, result json.RawMessage) {
+	if d.statusStore == nil {
+		return
+	}
+	status := Status{
+		UniqueId:    msg.UniqueId,
+		Key:         msg.Key,
+		State:       state,
+		Attempts:    attempts,
+		MaxAttempts: msg.MaxAttempts,
+		Result:      result,
+		UpdatedAt:   at,
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	if err := d.statusStore.Set(context.Background(), status); err != nil {
+		_ = level.Warn(d.logger).Log("err", errors.Wrapf(err, "failed to set the status of event %s", msg.Key))
+	}
+}
+
 func (d *QueueableDispatcher) Driver() Driver {
 	return d.driver
 }
 
-func (d *QueueableDispatcher) work(ctx context.Context, msg *PersistedEvent) {
-	ctx, cancel := context.WithTimeout(ctx, msg.HandleTimeout)
+func (d *QueueableDispatcher) work(jobCtx context.Context, msg *PersistedEvent) {
+	if d.limiter != nil {
+		release, ok := d.acquire(jobCtx, msg)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	// The timeout can be postponed by Heartbeat.
+	ctx, cancel := newLease(jobCtx, d.driver, msg)
+	defer cancel()
+	span, ctx := extract(ctx, d.tracer, msg)
+	defer span.Finish()
+	start := time.Now()
+	if msg.Attempts == 1 && !msg.DueAt.IsZero() {
+		observe(d.metrics.Latency, msg, start.Sub(msg.DueAt).Seconds())
+	}
+	d.setStatus(msg, StateRunning, msg.Attempts, nil, nil)
+	var result json.RawMessage
+	ctx = context.WithValue(ctx, resultKey{}, &result)
+	d.scaler.begin()
+	err := d.handler(ctx, msg)
+	d.scaler.end(time.Since(start))
+	observe(d.metrics.Duration, msg, time.Since(start).Seconds())
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("error", err.Error())
+	}
+	d.settle(jobCtx, msg, err, result)
+}
+
+// acquire waits for the limiter to allow the handling of the message. The waiting doesn't count towards the
+// HandleTimeout: if the driver is an Extender, the reservation is extended meanwhile. Otherwise, the message is
+// released once the HandleTimeout is reached, and acquire reports false. It also reports false if the limiter
+// fails, or the consumer stops.
+func (d *QueueableDispatcher) acquire(jobCtx context.Context, msg *PersistedEvent) (func(), bool) {
+	ctx, cancel := context.WithCancel(jobCtx)
 	defer cancel()
-	err := d.Dispatch(ctx, msg)
+	if extender, ok := d.driver.(Extender); ok {
+		go keepReserved(ctx, extender, msg, d.logger)
+	} else if msg.HandleTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, msg.HandleTimeout)
+		defer cancel()
+	}
+	release, err := d.limiter.Acquire(ctx, msg)
+	if err == nil {
+		return release, true
+	}
+	if jobCtx.Err() == nil {
+		_ = level.Warn(d.logger).Log("err", errors.Wrapf(err, "event %s is not handled, releasing", msg.Key))
+	}
+	d.release(msg)
+	return nil, false
+}
+
+// settle acknowledges, retries or fails the message according to the outcome of the handler.
+func (d *QueueableDispatcher) settle(jobCtx context.Context, msg *PersistedEvent, err error, result json.RawMessage) {
+	var panicErr PanicError
+	if errors.As(err, &panicErr) {
+		_ = level.Error(d.logger).Log("err", fmt.Sprintf("event %s panicked: %v\n%s", msg.Key, panicErr.Value, panicErr.Stack))
+	}
+	if err != nil && jobCtx.Err() != nil {
+		// The handler is interrupted by the shutdown. It is not counted as an attempt.
+		_ = level.Info(d.logger).Log("err", errors.Wrapf(err, "event %s is interrupted, releasing", msg.Key))
+		d.release(msg)
+		return
+	}
 	if err != nil {
 		if msg.Attempts < msg.MaxAttempts {
 			_ = level.Info(d.logger).Log("err", errors.Wrapf(err, "event %s failed %d times, retrying", msg.Key, msg.Attempts))
+			count(d.metrics.Retried, msg)
+			d.setStatus(msg, StateFailed, msg.Attempts, err, nil)
 			_ = d.Dispatch(context.Background(), events.Of(RetryingEvent{Err: err, Msg: msg}))
 			_ = d.driver.Retry(context.Background(), msg)
 			return
 		}
 		_ = level.Warn(d.logger).Log("err", errors.Wrapf(err, "event %s failed after %d attempts, aborted", msg.Key, msg.MaxAttempts))
+		count(d.metrics.Aborted, msg)
+		d.setStatus(msg, StateAborted, msg.Attempts, err, nil)
 		_ = d.Dispatch(context.Background(), events.Of(AbortedEvent{Err: err, Msg: msg}))
 		_ = d.driver.Fail(context.Background(), msg)
 		return
 	}
+	count(d.metrics.Processed, msg)
+	d.setStatus(msg, StateSucceeded, msg.Attempts, nil, result)
 	_ = d.driver.Ack(context.Background(), msg)
 }
 
+// handle is the innermost Handler. It dispatches the persisted event to the listeners.
+func (d *QueueableDispatcher) handle(ctx context.Context, msg *PersistedEvent) error {
+	return d.Dispatch(ctx, msg)
+}
+
+// decode reverses the persisted event to the original event data.
+func (d *QueueableDispatcher) decode(msg *PersistedEvent) (interface{}, error) {
+	rType := d.reflectType(msg.Type())
+	if rType == nil {
+		return nil, fmt.Errorf("unable to reverse engineer the event %s", msg.Type())
+	}
+	if upcaster, ok := d.upcasters[msg.Type()]; ok {
+		// The message is copied, as drivers may rely on the original one to acknowledge it.
+		upcasted := *msg
+		if err := upcaster(&upcasted); err != nil {
+			return nil, errors.Wrapf(err, "upcast %s from version %d failed", msg.Type(), msg.SchemaVersion)
+		}
+		msg = &upcasted
+	}
+	ptr := reflect.New(rType)
+	err := d.packerOf(msg.ContentType).Unmarshal(msg.Value, ptr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dispatch serialized %s failed", msg.Type())
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// packerOf returns the Packer for the content type. Payloads produced by other bundled packers can be decoded even
+// if the dispatcher is configured with a different one.
+func (d *QueueableDispatcher) packerOf(contentType string) Packer {
+	if contentType == "" || contentType == contentTypeOf(d.packer) {
+		return d.packer
+	}
+	switch contentType {
+	case GobContentType:
+		return packer{}
+	case JSONContentType:
+		return JSONPacker{}
+	case ProtobufContentType:
+		return ProtobufPacker{}
+	}
+	return d.packer
+}
+
 func (d *QueueableDispatcher) reflectType(typeName string) reflect.Type {
 	d.rwLock.RLock()
 	defer d.rwLock.RUnlock()
@@ -163,6 +500,9 @@ func (d *QueueableDispatcher) gauge(ctx context.Context) {
 	d.queueLengthGauge.With("channel", "delayed").Set(float64(queueInfo.Delayed))
 	d.queueLengthGauge.With("channel", "timeout").Set(float64(queueInfo.Timeout))
 	d.queueLengthGauge.With("channel", "waiting").Set(float64(queueInfo.Waiting))
+	for n, length := range queueInfo.Lanes {
+		d.queueLengthGauge.With("channel", fmt.Sprintf("waiting:%d", n)).Set(float64(length))
+	}
 }
 
 // UsePacker allows consumer to replace the default Packer with a custom one. UsePacker is an option for WithQueue.
@@ -172,6 +512,29 @@ func UsePacker(packer Packer) func(*QueueableDispatcher) {
 	}
 }
 
+// Upcaster converts the payload of an older schema version to the current one, by updating the Value and the
+// SchemaVersion of the message given. The message is a copy, so it is safe to modify it.
+//
+//  queue.UseUpcaster(events.Of(ReportEvent{}).Type(), func(message *queue.PersistedEvent) error {
+//    if message.SchemaVersion < 2 {
+//      // rename fields in message.Value
+//      message.SchemaVersion = 2
+//    }
+//    return nil
+//  })
+type Upcaster func(message *PersistedEvent) error
+
+// UseUpcaster is an option for WithQueue that registers an Upcaster for the event type. The Upcaster is called
+// before the payload is decoded, regardless of the SchemaVersion.
+func UseUpcaster(eventType string, upcaster Upcaster) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		if dispatcher.upcasters == nil {
+			dispatcher.upcasters = make(map[string]Upcaster)
+		}
+		dispatcher.upcasters[eventType] = upcaster
+	}
+}
+
 // UseLogger is an option for WithQueue that feeds the queue with a Logger of choice.
 func UseLogger(logger log.Logger) func(*QueueableDispatcher) {
 	return func(dispatcher *QueueableDispatcher) {
@@ -186,6 +549,26 @@ func UseParallelism(parallelism int) func(*QueueableDispatcher) {
 	}
 }
 
+// UseAdaptiveParallelism is an option for WithQueue that grows and shrinks the workers between min and max. Every
+// interval, the number of workers is adjusted by the length of the waiting channel and the average duration of
+// handlers, so that the waiting events can be handled within about an interval. Idle workers are stopped one at a
+// time. It overrides UseParallelism.
+func UseAdaptiveParallelism(min, max int, interval time.Duration) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		if min < 1 {
+			min = 1
+		}
+		if max < min {
+			max = min
+		}
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		dispatcher.parallelism = min
+		dispatcher.scaler = &scaler{min: min, max: max, interval: interval, workers: int64(min)}
+	}
+}
+
 // UseGauge is an option for WithQueue that collects a gauge metrics
 func UseGauge(gauge metrics.Gauge, interval time.Duration) func(*QueueableDispatcher) {
 	return func(dispatcher *QueueableDispatcher) {
@@ -194,20 +577,86 @@ func UseGauge(gauge metrics.Gauge, interval time.Duration) func(*QueueableDispat
 	}
 }
 
+// UseLimiter is an option for WithQueue that limits the concurrency and rate of handlers for each event type.
+// A worker waiting for the Limiter can't consume other events, so the parallelism should be set generously. Events
+// of batch listeners are not limited. See SubscribeBatch.
+func UseLimiter(limiter Limiter) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.limiter = limiter
+	}
+}
+
+// UseGracePeriod is an option for WithQueue that allows running handlers to finish within the given period, after
+// the consumer is asked to stop. Handlers still running afterwards are canceled, and their messages are returned to
+// the waiting channel if the driver implements Releaser. By default, handlers are canceled immediately.
+func UseGracePeriod(period time.Duration) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.gracePeriod = period
+	}
+}
+
+// UseMetrics is an option for WithQueue that collects the metrics of event handling. The metrics should be already
+// labeled by the queue name. The event label is added by the dispatcher.
+func UseMetrics(metrics *Metrics) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		if metrics != nil {
+			dispatcher.metrics = metrics
+		}
+	}
+}
+
+// UseMiddleware is an option for WithQueue that wraps the handling of each persisted event popped from the queue.
+// Middlewares are applied in order, so the first one is the outermost. Panics in listeners and middlewares are
+// always recovered, and treated as a failed attempt.
+func UseMiddleware(middlewares ...Middleware) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.middlewares = append(dispatcher.middlewares, middlewares...)
+	}
+}
+
+// UseListenerMiddleware is an option for WithQueue that wraps the Process method of the listeners subscribed to the
+// dispatcher, for both persisted and ordinary events. Unlike UseMiddleware, the middlewares see the decoded events.
+// They don't apply to BatchListeners.
+func UseListenerMiddleware(middlewares ...events.Middleware) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.listenerMiddlewares = append(dispatcher.listenerMiddlewares, middlewares...)
+	}
+}
+
+// UseStatusStore is an option for WithQueue that records the Status of each persisted event in the store, so that
+// producers can look up whether the event has been handled by its UniqueId. See QueueableDispatcher.Status.
+func UseStatusStore(store StatusStore) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.statusStore = store
+	}
+}
+
+// UseTracer is an option for WithQueue that traces the handling of persisted events. The span context of the producer
+// is carried by the message, so that the handling span becomes a child of it. By default, the global tracer is used.
+func UseTracer(tracer opentracing.Tracer) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.tracer = tracer
+	}
+}
+
 // WithQueue wraps a QueueableDispatcher and returns a decorated QueueableDispatcher. The latter QueueableDispatcher now can send and
 // listen to "persisted" events. Those persisted events will guarantee at least one execution, as they are stored in an
 // external storage and won't be released until the QueueableDispatcher acknowledges the end of execution.
 func WithQueue(baseDispatcher contract.Dispatcher, driver Driver, opts ...func(*QueueableDispatcher)) *QueueableDispatcher {
 	qd := QueueableDispatcher{
+		logger:       log.NewNopLogger(),
 		driver:       driver,
 		packer:       packer{},
 		rwLock:       sync.RWMutex{},
 		reflectTypes: make(map[string]reflect.Type),
 		base:         baseDispatcher,
 		parallelism:  runtime.NumCPU(),
+		tracer:       opentracing.GlobalTracer(),
+		metrics:      &Metrics{},
 	}
 	for _, f := range opts {
 		f(&qd)
 	}
+	qd.handler = recoverPanic(Chain(qd.middlewares...)(qd.handle))
 	return &qd
 }
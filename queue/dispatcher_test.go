@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/dtx"
 	"github.com/DoNewsCode/core/events"
 	"github.com/DoNewsCode/core/logging"
+	"github.com/go-kit/kit/metrics"
 	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 
 	"testing"
@@ -328,3 +333,301 @@ func TestDispatcher_Consume(t *testing.T) {
 		})
 	}
 }
+
+func TestDispatcher_drain(t *testing.T) {
+	cases := []struct {
+		name        string
+		gracePeriod time.Duration
+		finished    bool
+		info        QueueInfo
+	}{
+		{"finished within grace period", time.Second, true, QueueInfo{}},
+		{"released after grace period", 10 * time.Millisecond, false, QueueInfo{Waiting: 1}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+			dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseParallelism(1), UseGracePeriod(c.gracePeriod))
+			started := make(chan struct{})
+			finished := false
+			dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+				close(started)
+				select {
+				case <-time.After(100 * time.Millisecond):
+					finished = true
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}))
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				dispatcher.Consume(ctx)
+				close(done)
+			}()
+			assert.NoError(t, dispatcher.Dispatch(context.Background(), Persist(events.Of(MockEvent{}))))
+			<-started
+			cancel()
+			<-done
+
+			assert.Equal(t, c.finished, finished)
+			info, _ := driver.Info(context.Background())
+			assert.Equal(t, c.info, info)
+		})
+	}
+}
+
+func TestDispatcher_tracing(t *testing.T) {
+	tracer := mocktracer.New()
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseParallelism(1), UseTracer(tracer))
+
+	type received struct {
+		span          opentracing.Span
+		correlationID interface{}
+	}
+	ch := make(chan received, 1)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		ch <- received{opentracing.SpanFromContext(ctx), ctx.Value(dtx.CorrelationID)}
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Consume(ctx)
+
+	producer := tracer.StartSpan("producer")
+	producerCtx := opentracing.ContextWithSpan(context.Background(), producer)
+	producerCtx = context.WithValue(producerCtx, dtx.CorrelationID, "42")
+	assert.NoError(t, dispatcher.Dispatch(producerCtx, Persist(events.Of(MockEvent{}))))
+	producer.Finish()
+
+	r := <-ch
+	assert.Equal(t, "42", r.correlationID)
+	consumer := r.span.(*mocktracer.MockSpan)
+	assert.Equal(t, producer.(*mocktracer.MockSpan).SpanContext.TraceID, consumer.SpanContext.TraceID)
+	assert.Equal(t, producer.(*mocktracer.MockSpan).SpanContext.SpanID, consumer.ParentID)
+}
+
+type mockMetric struct {
+	lock        sync.Mutex
+	labelValues []string
+	values      []float64
+}
+
+func (m *mockMetric) With(labelValues ...string) *mockMetric {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.labelValues = append(m.labelValues, labelValues...)
+	return m
+}
+
+func (m *mockMetric) record(value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values = append(m.values, value)
+}
+
+func (m *mockMetric) count() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.values)
+}
+
+type mockCounter struct{ *mockMetric }
+
+func (m mockCounter) With(labelValues ...string) metrics.Counter {
+	m.mockMetric.With(labelValues...)
+	return m
+}
+func (m mockCounter) Add(delta float64) { m.record(delta) }
+
+type mockHistogram struct{ *mockMetric }
+
+func (m mockHistogram) With(labelValues ...string) metrics.Histogram {
+	m.mockMetric.With(labelValues...)
+	return m
+}
+func (m mockHistogram) Observe(value float64) { m.record(value) }
+
+func TestDispatcher_metrics(t *testing.T) {
+	var (
+		duration, latency           = &mockMetric{}, &mockMetric{}
+		processed, retried, aborted = &mockMetric{}, &mockMetric{}, &mockMetric{}
+	)
+	m := &Metrics{
+		Duration:  mockHistogram{duration},
+		Latency:   mockHistogram{latency},
+		Processed: mockCounter{processed},
+		Retried:   mockCounter{retried},
+		Aborted:   mockCounter{aborted},
+	}
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseParallelism(1), UseMetrics(m))
+	done := make(chan struct{}, 3)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		defer func() { done <- struct{}{} }()
+		if event.Data().(MockEvent).Value == "fail" {
+			return errors.New("some err")
+		}
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Consume(ctx)
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "ok"}))))
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "fail"}), MaxAttempts(2), FixedBackoff(time.Hour))))
+	<-done
+	<-done
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 2, duration.count())
+	assert.Equal(t, 2, latency.count())
+	assert.Equal(t, 1, processed.count())
+	assert.Equal(t, 1, retried.count())
+	assert.Equal(t, 0, aborted.count())
+	assert.Equal(t, []string{"event", events.Of(MockEvent{}).Type()}, processed.labelValues)
+}
+
+func TestDispatcher_heartbeat(t *testing.T) {
+	assert.ErrorIs(t, Heartbeat(context.Background()), ErrNoHeartbeat)
+
+	cases := []struct {
+		name      string
+		heartbeat bool
+	}{
+		{"with heartbeat", true},
+		{"without heartbeat", false},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+			dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseParallelism(1))
+			done := make(chan error)
+			dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+				var err error
+				for i := 0; i < 6 && err == nil; i++ {
+					select {
+					case <-time.After(20 * time.Millisecond):
+					case <-ctx.Done():
+						err = ctx.Err()
+					}
+					if c.heartbeat && err == nil {
+						err = Heartbeat(ctx)
+					}
+				}
+				done <- err
+				return err
+			}))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go dispatcher.Consume(ctx)
+
+			assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}), Timeout(50*time.Millisecond))))
+			err := <-done
+			if !c.heartbeat {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				return
+			}
+			assert.NoError(t, err)
+			// The message is never moved to the timeout channel.
+			time.Sleep(20 * time.Millisecond)
+			info, _ := driver.Info(context.Background())
+			assert.Equal(t, QueueInfo{}, info)
+		})
+	}
+}
+
+func TestDispatcher_middleware(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		trace []string
+	)
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, message *PersistedEvent) error {
+				lock.Lock()
+				trace = append(trace, name)
+				lock.Unlock()
+				return next(ctx, message)
+			}
+		}
+	}
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(
+		&events.SyncDispatcher{},
+		driver,
+		UseParallelism(1),
+		UseMiddleware(record("outer"), record("inner")),
+	)
+	aborted := make(chan error)
+	dispatcher.Subscribe(AbortedListener(func(ctx context.Context, event contract.Event) error {
+		aborted <- event.Data().(AbortedEvent).Err
+		return nil
+	}))
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		panic("boom")
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Consume(ctx)
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}))))
+	err := <-aborted
+	var panicErr PanicError
+	assert.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, "boom", panicErr.Value)
+	lock.Lock()
+	assert.Equal(t, []string{"outer", "inner"}, trace)
+	lock.Unlock()
+}
+
+func TestDispatcher_listenerMiddleware(t *testing.T) {
+	var seen []string
+	dispatcher := WithQueue(
+		&events.SyncDispatcher{},
+		NewInProcessDriverWithPopInterval(time.Millisecond),
+		UseParallelism(1),
+		UseListenerMiddleware(events.Recover(), func(listener contract.Listener, next events.Handler) events.Handler {
+			return func(ctx context.Context, event contract.Event) error {
+				seen = append(seen, event.Type())
+				return next(ctx, event)
+			}
+		}),
+	)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		panic("boom")
+	}))
+
+	err := dispatcher.Dispatch(context.Background(), events.Of(MockEvent{}))
+	var panicErr events.PanicError
+	assert.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, []string{events.Of(MockEvent{}).Type()}, seen)
+}
+
+func TestDispatcher_SubscribeWithHandle(t *testing.T) {
+	var called int
+	dispatcher := WithQueue(&events.SyncDispatcher{}, NewInProcessDriver())
+	sub := dispatcher.SubscribeWithHandle(MockListener(func(ctx context.Context, event contract.Event) error {
+		called++
+		return nil
+	}))
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), events.Of(MockEvent{})))
+	sub.Unsubscribe()
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), events.Of(MockEvent{})))
+	assert.Equal(t, 1, called)
+
+	dispatcher = WithQueue(mockDispatcher{}, NewInProcessDriver())
+	assert.Panics(t, func() {
+		dispatcher.SubscribeWithHandle(MockListener(nil))
+	})
+}
+
+type mockDispatcher struct{}
+
+func (m mockDispatcher) Dispatch(ctx context.Context, event contract.Event) error { return nil }
+
+func (m mockDispatcher) Subscribe(listener contract.Listener) {}
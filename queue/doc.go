@@ -37,7 +37,8 @@
 //  queueableDispatcher.dispatch(pevent)
 //
 // As you see, how the queue persist the events is subject to the underlying driver. The default driver bundled in this
-// package is the redis driver.
+// package is the redis driver. A GormDriver and a KafkaDriver are also available, for projects already relying on
+// databases or kafka.
 //
 // Once the persisted event are stored in the external storage, a goroutine should consume them and pipe the
 // reconstructed event to the listeners. This is done by calling the Consume method of queue.Dispatcher
@@ -48,15 +49,110 @@
 // used interchangeably. But note if a event is retryable, it is your responsibility to ensure the idempotency.
 // Also, be aware if a persisted event have many listeners, the event is up to retry when any of the listeners fail.
 //
+// By default, the delay between retries roughly doubles each time. The retry policy can be chosen per event:
+//
+//  queue.Persist(event, queue.MaxAttempts(5), queue.ExponentialBackoff(time.Second))
+//  queue.Persist(event, queue.MaxAttempts(5), queue.FixedBackoff(time.Minute))
+//
+// Custom policies can be registered by name with RegisterBackoffPolicy.
+//
+// Events can also be deduplicated by their unique id. While an event with the same id is still in the queue, the
+// new one is either dropped or replaces the old one:
+//
+//  queue.Persist(event, queue.UniqueId("report:"+userId), queue.Unique(queue.DropDuplicate))
+//
+// Deduplication is supported by the redis driver and the in process driver.
+//
+// Urgent events can skip ahead of the others with the Priority option. Events with higher priority are put onto
+// higher waiting lanes, which are consumed first. Lower lanes are still served from time to time, so they won't
+// starve. The number of lanes in redis is set by the "lanes" entry in the queue configuration.
+//
+//  queue.Persist(event, queue.Priority(1))
+//
+// The opentracing span and the dtx.CorrelationID in the context passed to Dispatch are stored along with the event.
+// When the event is consumed, the listeners receive a context with the restored correlation id and a new span,
+// which is a child of the producer's span.
+//
+// Handlers running longer than the HandleTimeout (see the Timeout option) are canceled, and their events are moved
+// to the timeout channel. Long running handlers can call Heartbeat periodically to keep their events reserved.
+//
+//  queue.Persist(event, queue.Timeout(time.Minute))
+//  // in the handler
+//  err := queue.Heartbeat(ctx)
+//
 // Integrate
 //
 // The queue package exports configuration in this format:
 //
 //  queue:
 //    default:
+//      driver: redis
 //      redisName: default
 //      parallelism: 3
 //      checkQueueLengthIntervalSecond: 15
+//      gracePeriodSecond: 10
+//
+// The driver can be "redis", "redisStream", "gorm" or "file". The gorm driver stores messages in the database
+// connection named by gormName. Its tables are created by the "database migrate" command, as the queue dependency is
+// also a otgorm.MigrationProvider.
+//
+//  queue:
+//    default:
+//      driver: gorm
+//      gormName: default
+//
+// The driver "redisStream" is an alternative redis driver built on redis streams. Messages are delivered through
+// a consumer group, and messages left behind by crashed consumers are claimed by the others after HandleTimeout.
+// Priority lanes and deduplication are not supported by it.
+//
+//  queue:
+//    default:
+//      driver: redisStream
+//      redisName: default
+//
+// The driver "file" keeps messages in an append-only log on the local disk, at the path given. It needs no external
+// storage, and messages survive restarts. Only one process can use the log at a time, so it suits single node
+// deployments.
+//
+//  queue:
+//    default:
+//      driver: file
+//      path: data/queue/default.log
+//
+// The number of workers can grow with the load. When maxParallelism is larger than parallelism, the workers are
+// adjusted every scaleIntervalSecond (5 by default), by the length of the waiting channel and the average duration
+// of handlers. They never drop below parallelism.
+//
+//  queue:
+//    default:
+//      parallelism: 2
+//      maxParallelism: 16
+//      scaleIntervalSecond: 5
+//
+// By default, events are serialized with gob. Set the packer to "json" or "protobuf" to use those formats instead.
+// With them, messages are also saved in a JSON envelope (see queue.Envelope), so that producers and consumers in
+// other languages can work with the queue. The envelope records the content type and the schema version of the
+// payload. When an event struct evolves, bump its version with the SchemaVersion option, and convert older payloads
+// with an Upcaster (see UseUpcaster).
+//
+//  queue:
+//    default:
+//      packer: json
+//
+// The handlers of each event type can be limited in concurrency and rate (per second). By default the limits apply
+// to each process. Set the limiter to "redis" to enforce them across all consumers of the queue.
+//
+//  queue:
+//    default:
+//      limiter: redis
+//      limits:
+//        - event: foo.ReportEvent
+//          concurrency: 2
+//          rate: 50
+//
+// On shutdown, the consumer stops popping new events, and waits for the running handlers for at most
+// gracePeriodSecond. Unfinished events are then put back onto the waiting channel, so that they can be picked up
+// by other consumers without waiting for the handle timeout.
 //
 // While manually constructing the queue.Dispatcher is absolutely feasible, users can use the bundled dependency provider
 // without breaking a sweat. Using this approach, the life cycle of consumer goroutine will be managed
@@ -66,7 +162,8 @@
 //  c.Provide(otredis.Providers()) // to provide the redis driver
 //  c.Provide(queue.Providers())
 //
-// A module is also bundled, providing the queue command (for reloading and flushing).
+// A module is also bundled, providing the queue command (for reloading and flushing). When the driver implements
+// Inspector, failed messages can also be listed, shown, retried and deleted one by one.
 //
 //  c.AddModuleFunc(queue.New)
 //
@@ -79,6 +176,86 @@
 //    // see examples for details
 //  })
 //
+// Alternatively, list the event types or packages in the routes of a queue. Persistent events dispatched by any
+// QueueableDispatcher made by the factory, including the default queue.Dispatcher, are then forwarded to that
+// queue. The underlying contract.Dispatcher doesn't know about queues, so events dispatched through it directly are
+// not routed. The queue.OnQueue option overrides the routes for a single event.
+//
+//  queue:
+//    default:
+//      driver: redis
+//    emails:
+//      driver: redis
+//      routes:
+//        - github.com/foo/mail.SendEvent
+//        - github.com/foo/notification
+//
+//  dispatcher.Dispatch(ctx, queue.Persist(event, queue.OnQueue("emails")))
+//
+// Middleware
+//
+// The handling of each persisted event can be wrapped by middlewares, for example to restore the tenant in the
+// context or to log the events. Panics in listeners are always recovered, and count as a failed attempt.
+//
+//  queueableDispatcher := queue.WithQueue(&events.SyncDispatcher, &queue.RedisDriver{}, queue.UseMiddleware(
+//    func(next queue.Handler) queue.Handler {
+//      return func(ctx context.Context, message *queue.PersistedEvent) error {
+//        // before
+//        return next(ctx, message)
+//      }
+//    },
+//  ))
+//
+// When using the dependency provider, inject a queue.Middleware into the core. Several middlewares can be combined
+// with queue.Chain.
+//
+// To wrap the listeners themselves, use the event middlewares from package events, such as events.Trace and
+// events.Measure. They see the decoded events, and apply to ordinary events as well.
+//
+//  queueableDispatcher := queue.WithQueue(&events.SyncDispatcher, &queue.RedisDriver{}, queue.UseListenerMiddleware(
+//    events.Trace(tracer), events.Log(logger),
+//  ))
+//
+// Batch Listeners
+//
+// Some events are far cheaper to handle in bulk. A queue.BatchListener is called with up to N events of the same
+// type at once, or with whatever collected after waiting for T. Each message in the batch is still acknowledged,
+// retried or failed on its own, by returning a queue.BatchError.
+//
+//  dispatcher.SubscribeBatch(indexListener, 500, time.Second)
+//
+// Job Status
+//
+// Producers can look up whether a persisted event has been handled by its UniqueId, if the dispatcher is given a
+// StatusStore. The status records the state (queued, running, succeeded, failed or aborted), the attempts, the
+// last error and an optional result set by the listener with queue.SetResult.
+//
+//  dispatcher.Dispatch(ctx, queue.Persist(event, queue.UniqueId(exportId)))
+//  status, err := dispatcher.Status(ctx, exportId)
+//
+// With the dependency provider, select the store by statusStore. The redis store keeps statuses for
+// statusTTLSecond (24 hours by default), while the gorm store saves them in the queue_statuses table.
+//
+//  queue:
+//    default:
+//      statusStore: redis
+//      statusTTLSecond: 3600
+//
+// The StatusModule serves the statuses over HTTP at "/queue/{queue}/jobs/{uniqueId}".
+//
+//  c.AddModuleFunc(queue.NewStatusModule)
+//
+// Recurring Events
+//
+// ScheduleAt defers a single execution. To dispatch persisted events periodically, add them to a queue.Scheduler
+// by cron expressions. All instances can run the Scheduler, while a Locker, such as queue.RedisLocker or
+// queue.LeaderLocker, makes sure each occurrence is enqueued once. The events are then handled like any other
+// persisted events, with retries.
+//
+//  scheduler := queue.NewScheduler(dispatcher, queue.LeaderLocker{Status: status})
+//  scheduler.Add("cleanup", "@every 10m", events.Of(CleanupEvent{}), queue.MaxAttempts(3))
+//  c.AddModule(scheduler)
+//
 // Events
 //
 // When an attempt to execute the event handler failed, two kinds of event will be fired. If the failed event can be
@@ -99,4 +276,10 @@
 //      }, []string{"name", "channel"},
 //    )
 //  }})
+//
+// The handling of events is measured by queue.Metrics: the handler duration, the time spent waiting in queue, and
+// the number of events processed, retried and aborted, all labeled by queue and event type. The observability
+// package provides them with prometheus:
+//
+//  c.Provide(observability.Providers())
 package queue
@@ -9,10 +9,18 @@ import (
 // ErrEmpty means the queue is empty.
 var ErrEmpty = errors.New("no message available")
 
+// ErrNotFound means the message is not found in the channel.
+var ErrNotFound = errors.New("message not found")
+
+// ErrDuplicate means the message is dropped by Push, as another one with the same UniqueId is still in the queue.
+// See DropDuplicate.
+var ErrDuplicate = errors.New("message dropped as a duplicate")
+
 // Driver is the interface for queue engines. See RedisDriver for usage.
 type Driver interface {
 	// Push pushes the message onto the queue. It is possible to specify a time delay. If so the message
-	// will be read after the delay. Use zero value if a delay is not needed.
+	// will be read after the delay. Use zero value if a delay is not needed. If the message is dropped as a
+	// duplicate, ErrDuplicate is returned.
 	Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error
 	// Pop pops the message out of the queue. It blocks until a message is available or a timeout is reached.
 	Pop(ctx context.Context) (*PersistedEvent, error)
@@ -32,3 +40,32 @@ type Driver interface {
 	// Retry put the message back onto the delayed queue.
 	Retry(ctx context.Context, message *PersistedEvent) error
 }
+
+// Inspector is an optional interface for Driver. Drivers implementing it allow
+// operators to browse the messages in a channel and to act on individual ones,
+// for example through the queue list, show, retry and delete commands.
+// Channels are referred by their plain names, such as "failed" or "delayed".
+type Inspector interface {
+	// List returns at most limit messages from the channel, skipping the first offset ones.
+	List(ctx context.Context, channel string, offset, limit int64) ([]*PersistedEvent, error)
+	// Remove deletes the message with the given UniqueId from the channel and returns it.
+	// If no such message exists, ErrNotFound is returned.
+	Remove(ctx context.Context, channel string, uniqueId string) (*PersistedEvent, error)
+}
+
+// Releaser is an optional interface for Driver. Drivers implementing it can put
+// a reserved message back onto the waiting channel right away, without counting
+// an attempt. It is used to return unfinished messages when the consumer stops.
+type Releaser interface {
+	// Release puts the reserved message back onto the waiting channel.
+	Release(ctx context.Context, message *PersistedEvent) error
+}
+
+// Extender is an optional interface for Driver. Drivers implementing it allow
+// long running handlers to keep their messages reserved beyond the HandleTimeout.
+// See Heartbeat.
+type Extender interface {
+	// Extend keeps the reserved message from timing out for another period of timeout, counting from now.
+	// If the message is no longer reserved, ErrNotFound is returned.
+	Extend(ctx context.Context, message *PersistedEvent, timeout time.Duration) error
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EnvelopeVersion is the version of the Envelope format written by EnvelopePacker.
+const EnvelopeVersion = 1
+
+// Envelope is the wire format of PersistedEvent written by EnvelopePacker. It is plain JSON, so that events can be
+// enqueued and inspected by other languages. Durations are in nanoseconds.
+//
+// The payload is embedded as is if its content type is JSON. Otherwise, it is saved in PayloadBase64.
+type Envelope struct {
+	Version       int             `json:"version"`
+	ContentType   string          `json:"contentType,omitempty"`
+	SchemaVersion int             `json:"schemaVersion,omitempty"`
+	UniqueId      string          `json:"uniqueId"`
+	Key           string          `json:"key"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	PayloadBase64 []byte          `json:"payloadBase64,omitempty"`
+	HandleTimeout time.Duration   `json:"handleTimeout"`
+	Backoff       time.Duration   `json:"backoff"`
+	BackoffPolicy string          `json:"backoffPolicy,omitempty"`
+	UniquePolicy  string          `json:"uniquePolicy,omitempty"`
+	Priority      int             `json:"priority,omitempty"`
+	Attempts      int             `json:"attempts"`
+	MaxAttempts   int             `json:"maxAttempts"`
+	DueAt         time.Time       `json:"dueAt"`
+	SpanContext   []string        `json:"spanContext,omitempty"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+}
+
+// EnvelopePacker is a Packer for drivers, which saves PersistedEvent in an Envelope. Messages saved by the default
+// gob packer can still be read, so it is safe to switch existing queues to it.
+type EnvelopePacker struct{}
+
+// Marshal wraps the *PersistedEvent in an Envelope.
+func (p EnvelopePacker) Marshal(message interface{}) ([]byte, error) {
+	msg, ok := message.(*PersistedEvent)
+	if !ok {
+		return nil, fmt.Errorf("EnvelopePacker only packs *PersistedEvent, got %T", message)
+	}
+	envelope := Envelope{
+		Version:       EnvelopeVersion,
+		ContentType:   msg.ContentType,
+		SchemaVersion: msg.SchemaVersion,
+		UniqueId:      msg.UniqueId,
+		Key:           msg.Key,
+		HandleTimeout: msg.HandleTimeout,
+		Backoff:       msg.Backoff,
+		BackoffPolicy: msg.BackoffPolicy,
+		UniquePolicy:  msg.UniquePolicy,
+		Priority:      msg.Priority,
+		Attempts:      msg.Attempts,
+		MaxAttempts:   msg.MaxAttempts,
+		DueAt:         msg.DueAt,
+		SpanContext:   msg.SpanContext,
+		CorrelationID: msg.CorrelationID,
+	}
+	if msg.ContentType == JSONContentType && json.Valid(msg.Value) {
+		envelope.Payload = msg.Value
+	} else {
+		envelope.PayloadBase64 = msg.Value
+	}
+	return json.Marshal(envelope)
+}
+
+// Unmarshal reverses the Envelope to the *PersistedEvent.
+func (p EnvelopePacker) Unmarshal(data []byte, message interface{}) error {
+	msg, ok := message.(*PersistedEvent)
+	if !ok {
+		return fmt.Errorf("EnvelopePacker only unpacks *PersistedEvent, got %T", message)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return packer{}.Unmarshal(data, message)
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+	if envelope.Version > EnvelopeVersion {
+		return fmt.Errorf("unsupported envelope version %d", envelope.Version)
+	}
+	*msg = PersistedEvent{
+		UniqueId:      envelope.UniqueId,
+		Key:           envelope.Key,
+		Value:         envelope.PayloadBase64,
+		ContentType:   envelope.ContentType,
+		SchemaVersion: envelope.SchemaVersion,
+		HandleTimeout: envelope.HandleTimeout,
+		Backoff:       envelope.Backoff,
+		BackoffPolicy: envelope.BackoffPolicy,
+		UniquePolicy:  envelope.UniquePolicy,
+		Priority:      envelope.Priority,
+		Attempts:      envelope.Attempts,
+		MaxAttempts:   envelope.MaxAttempts,
+		DueAt:         envelope.DueAt,
+		SpanContext:   envelope.SpanContext,
+		CorrelationID: envelope.CorrelationID,
+	}
+	if len(envelope.Payload) > 0 {
+		msg.Value = envelope.Payload
+	}
+	return nil
+}
+
+// ContentType implements ContentTyper.
+func (p EnvelopePacker) ContentType() string {
+	return JSONContentType
+}
@@ -0,0 +1,466 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// compactThreshold is the minimum number of records in the log before it is compacted.
+const compactThreshold = 1000
+
+// fileRecord is a line in the log of FileDriver. A "put" record saves the latest state of a message, while a
+// "del" record removes it.
+type fileRecord struct {
+	Op          string    `json:"op"`
+	ID          uint64    `json:"id"`
+	Channel     string    `json:"channel,omitempty"`
+	AvailableAt time.Time `json:"availableAt,omitempty"`
+	Payload     []byte    `json:"payload,omitempty"`
+}
+
+// FileDriver is a queue driver backed by an append-only log on the local disk. It is useful for single node
+// deployments without redis, as messages survive restarts. Every change is appended to the log and synced to the
+// disk before returning. The log is compacted on startup, and whenever most of its records are stale.
+//
+// Messages left reserved by a crashed process are put back onto the waiting channel on startup. Only one process
+// can use the file at a time. Priority lanes and deduplication are not supported.
+//
+// The channel names accepted by Reload and Flush are the plain names "failed", "timeout", etc.
+type FileDriver struct {
+	Path        string        // Path is the file of the log. Its directory is created if missing.
+	PopInterval time.Duration // PopInterval is the polling interval when no message is available.
+	Packer      Packer        // Packer describes how to save the message in wire format
+	Logger      log.Logger    // Logger is an optional logger. By default a noop logger is used
+
+	lock     sync.Mutex
+	file     *os.File
+	rows     map[uint64]*fileRecord
+	records  int
+	nextID   uint64
+	reserved map[*PersistedEvent]uint64
+}
+
+// Push pushes the message onto the queue. It is possible to specify a time delay. If so the message
+// will be read after the delay. Use zero value if a delay is not needed.
+func (f *FileDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.open(); err != nil {
+		return err
+	}
+	data, err := f.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	row := fileRecord{Op: "put", ID: f.nextID + 1, Channel: channelWaiting, AvailableAt: time.Now(), Payload: data}
+	if delay > 0 {
+		row.Channel = channelDelayed
+		row.AvailableAt = row.AvailableAt.Add(delay)
+	}
+	return errors.Wrap(f.write(row), "failed to append while pushing")
+}
+
+// Pop pops the message out of the queue. The log is not read again, so Pop only scans the messages in memory. If
+// there is none, Pop blocks for PopInterval before returning ErrEmpty.
+func (f *FileDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
+	message, err := f.pop()
+	if errors.Is(err, ErrEmpty) {
+		select {
+		case <-time.After(f.PopInterval):
+			return nil, ErrEmpty
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return message, err
+}
+
+func (f *FileDriver) pop() (*PersistedEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var (
+		changes []fileRecord
+		waiting []fileRecord
+	)
+	for _, row := range f.rows {
+		switch {
+		case row.Channel == channelDelayed && !row.AvailableAt.After(now):
+			changes = append(changes, f.moved(row, channelWaiting, row.AvailableAt))
+			waiting = append(waiting, changes[len(changes)-1])
+		case row.Channel == channelReserved && !row.AvailableAt.After(now):
+			changes = append(changes, f.moved(row, channelTimeout, row.AvailableAt))
+		case row.Channel == channelWaiting:
+			waiting = append(waiting, *row)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].ID < waiting[j].ID
+	})
+	var (
+		next    *fileRecord
+		message PersistedEvent
+	)
+	for i := range waiting {
+		if err := f.Packer.Unmarshal(waiting[i].Payload, &message); err != nil {
+			// The record would be popped again and again. It is moved out of the way.
+			_ = level.Warn(f.Logger).Log("err", errors.Wrapf(err, "failed to decompress message %d, moving to the failed channel", waiting[i].ID))
+			changes = append(changes, f.moved(&waiting[i], channelFailed, now))
+			message = PersistedEvent{}
+			continue
+		}
+		next = &waiting[i]
+		break
+	}
+	if next == nil {
+		if err := f.write(changes...); err != nil {
+			return nil, errors.Wrap(err, "failed to append while popping")
+		}
+		return nil, ErrEmpty
+	}
+	changes = append(changes, f.moved(next, channelReserved, now.Add(message.HandleTimeout)))
+	if err := f.write(changes...); err != nil {
+		return nil, errors.Wrap(err, "failed to append while popping")
+	}
+	f.reserved[&message] = next.ID
+	return &message, nil
+}
+
+// Ack acknowledges a message has been processed.
+func (f *FileDriver) Ack(ctx context.Context, message *PersistedEvent) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id, err := f.release(message)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(f.write(fileRecord{Op: "del", ID: id}), "failed to append while acknowledging message")
+}
+
+// Fail marks a message has failed.
+func (f *FileDriver) Fail(ctx context.Context, message *PersistedEvent) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id, err := f.release(message)
+	if err != nil {
+		return err
+	}
+	message.Attempts++
+	data, err := f.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	row := fileRecord{Op: "put", ID: id, Channel: channelFailed, AvailableAt: time.Now(), Payload: data}
+	return errors.Wrap(f.write(row), "failed to append while failing message")
+}
+
+// Release puts a reserved message back onto the waiting channel right away, without counting an attempt. It
+// implements Releaser.
+func (f *FileDriver) Release(ctx context.Context, message *PersistedEvent) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id, err := f.release(message)
+	if err != nil {
+		return err
+	}
+	row, ok := f.rows[id]
+	if !ok || row.Channel != channelReserved {
+		return nil
+	}
+	return errors.Wrap(f.write(f.moved(row, channelWaiting, time.Now())), "failed to append while releasing message")
+}
+
+// Extend postpones the timeout of a reserved message. It implements Extender.
+func (f *FileDriver) Extend(ctx context.Context, message *PersistedEvent, timeout time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id, ok := f.reserved[message]
+	if !ok {
+		return ErrNotFound
+	}
+	row, ok := f.rows[id]
+	if !ok || row.Channel != channelReserved {
+		return ErrNotFound
+	}
+	err := f.write(f.moved(row, channelReserved, time.Now().Add(timeout)))
+	return errors.Wrap(err, "failed to append while extending reservation")
+}
+
+// Reload put failed/timeout message back to the Waiting queue. If the temporary outage have been cleared,
+// messages can be tried again via Reload. Reload is not a normal retry.
+// It similarly gives otherwise dead messages one more chance,
+// but this chance is not subject to the limit of MaxAttempts, nor does it reset the number of time attempted.
+func (f *FileDriver) Reload(ctx context.Context, channel string) (int64, error) {
+	if channel != channelFailed && channel != channelTimeout {
+		return 0, fmt.Errorf("reloading %s is not allowed", channel)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	var changes []fileRecord
+	for _, row := range f.rows {
+		if row.Channel == channel {
+			changes = append(changes, f.moved(row, channelWaiting, time.Now()))
+		}
+	}
+	if err := f.write(changes...); err != nil {
+		return 0, errors.Wrapf(err, "failed to append %s while reloading", channel)
+	}
+	return int64(len(changes)), nil
+}
+
+// Flush flushes a queue of choice by deleting all its data. Use with caution.
+func (f *FileDriver) Flush(ctx context.Context, channel string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.open(); err != nil {
+		return err
+	}
+	var changes []fileRecord
+	for _, row := range f.rows {
+		if row.Channel == channel {
+			changes = append(changes, fileRecord{Op: "del", ID: row.ID})
+		}
+	}
+	return errors.Wrapf(f.write(changes...), "failed to flush %s", channel)
+}
+
+// Info lists QueueInfo by counting messages in each channel. Useful for metrics and monitor.
+func (f *FileDriver) Info(ctx context.Context) (QueueInfo, error) {
+	var info QueueInfo
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.open(); err != nil {
+		return info, err
+	}
+	for _, row := range f.rows {
+		switch row.Channel {
+		case channelWaiting:
+			info.Waiting++
+		case channelDelayed:
+			info.Delayed++
+		case channelTimeout:
+			info.Timeout++
+		case channelFailed:
+			info.Failed++
+		}
+	}
+	return info, nil
+}
+
+// Retry put the message back onto the delayed queue. The message will be tried after a period of time specified
+// by Backoff. Note: if one listener failed, all listeners for this event will have to be retried. Make sure
+// your listeners are idempotent as always.
+func (f *FileDriver) Retry(ctx context.Context, message *PersistedEvent) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id, err := f.release(message)
+	if err != nil {
+		return err
+	}
+	delay := time.Now().Add(nextBackoff(message))
+	message.Attempts++
+	data, err := f.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	row := fileRecord{Op: "put", ID: id, Channel: channelDelayed, AvailableAt: delay, Payload: data}
+	return errors.Wrap(f.write(row), "failed to append while retrying")
+}
+
+// Close closes the log file. The driver can't be used afterwards.
+func (f *FileDriver) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// release forgets a reserved message and returns its id. The caller must hold the lock.
+func (f *FileDriver) release(message *PersistedEvent) (uint64, error) {
+	id, ok := f.reserved[message]
+	if !ok {
+		return 0, fmt.Errorf("message %s is not reserved by this driver", message.UniqueId)
+	}
+	delete(f.reserved, message)
+	return id, nil
+}
+
+// moved returns a record that moves the row to the channel.
+func (f *FileDriver) moved(row *fileRecord, channel string, availableAt time.Time) fileRecord {
+	return fileRecord{Op: "put", ID: row.ID, Channel: channel, AvailableAt: availableAt, Payload: row.Payload}
+}
+
+// write appends the records to the log, and applies them once they are synced. The caller must hold the lock.
+func (f *FileDriver) write(records ...fileRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if _, err := f.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	for _, record := range records {
+		f.apply(record)
+	}
+	if f.records > compactThreshold && f.records > 2*len(f.rows) {
+		if err := f.compact(); err != nil {
+			_ = level.Warn(f.Logger).Log("err", errors.Wrap(err, "failed to compact the queue log"))
+		}
+	}
+	return nil
+}
+
+// apply updates the messages in memory with the record.
+func (f *FileDriver) apply(record fileRecord) {
+	f.records++
+	if record.ID > f.nextID {
+		f.nextID = record.ID
+	}
+	if record.Op == "del" {
+		delete(f.rows, record.ID)
+		return
+	}
+	f.rows[record.ID] = &record
+}
+
+// open loads the log on first use. Reserved messages are put back onto the waiting channel, and the log is
+// compacted. The caller must hold the lock.
+func (f *FileDriver) open() error {
+	if f.file != nil {
+		return nil
+	}
+	if f.Path == "" {
+		return errors.New("the path of the file driver is empty")
+	}
+	if f.Packer == nil {
+		f.Packer = packer{}
+	}
+	if f.Logger == nil {
+		f.Logger = log.NewNopLogger()
+	}
+	if f.PopInterval == 0 {
+		f.PopInterval = time.Second
+	}
+	f.rows = make(map[uint64]*fileRecord)
+	f.reserved = make(map[*PersistedEvent]uint64)
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create the directory of the queue log")
+	}
+	if err := f.load(); err != nil {
+		return errors.Wrapf(err, "failed to load the queue log %s", f.Path)
+	}
+	now := time.Now()
+	for id, row := range f.rows {
+		if row.Channel == channelReserved {
+			f.rows[id] = &fileRecord{Op: "put", ID: id, Channel: channelWaiting, AvailableAt: now, Payload: row.Payload}
+		}
+	}
+	if err := f.compact(); err != nil {
+		return errors.Wrapf(err, "failed to compact the queue log %s", f.Path)
+	}
+	return nil
+}
+
+// load replays the log. A partially written record at the end, left by a crash, is discarded.
+func (f *FileDriver) load() error {
+	file, err := os.Open(f.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				_ = level.Warn(f.Logger).Log("msg", "discarding the incomplete record at the end of the queue log")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return errors.Wrap(err, "corrupted record")
+		}
+		f.apply(record)
+	}
+}
+
+// compact rewrites the log with the messages in memory. The new log replaces the old one atomically.
+func (f *FileDriver) compact() error {
+	tmp := f.Path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, row := range f.rows {
+		if err := encoder.Encode(row); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.Path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(f.Path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, err = os.OpenFile(f.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.records = len(f.rows)
+	return nil
+}
@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpFileDriver(t *testing.T) *FileDriver {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &FileDriver{Path: filepath.Join(dir, "queue", "default.log"), PopInterval: time.Millisecond}
+}
+
+func TestFileDriver_lifecycle(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpFileDriver(t)
+	defer driver.Close()
+
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "foo", HandleTimeout: time.Hour, Attempts: 1}, 0))
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "bar", HandleTimeout: time.Hour, Attempts: 1}, time.Hour))
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Waiting: 1, Delayed: 1}, info)
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", msg.UniqueId)
+	_, err = driver.Pop(ctx)
+	assert.ErrorIs(t, err, ErrEmpty)
+
+	assert.NoError(t, driver.Fail(ctx, msg))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Delayed: 1, Failed: 1}, info)
+
+	n, err := driver.Reload(ctx, "failed")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	msg, _ = driver.Pop(ctx)
+	assert.Equal(t, 2, msg.Attempts)
+	assert.NoError(t, driver.Ack(ctx, msg))
+
+	assert.NoError(t, driver.Flush(ctx, "delayed"))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, QueueInfo{}, info)
+}
+
+func TestFileDriver_recovery(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpFileDriver(t)
+	for _, id := range []string{"foo", "bar", "baz"} {
+		assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: id, HandleTimeout: time.Hour, Attempts: 1, MaxAttempts: 2}, 0))
+	}
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "qux", HandleTimeout: time.Hour}, time.Hour))
+	foo, _ := driver.Pop(ctx)
+	assert.NoError(t, driver.Ack(ctx, foo))
+	bar, _ := driver.Pop(ctx)
+	assert.NoError(t, driver.Retry(ctx, bar))
+	// baz is left reserved, as if the process has crashed.
+	baz, _ := driver.Pop(ctx)
+	assert.Equal(t, "baz", baz.UniqueId)
+	assert.NoError(t, driver.Close())
+
+	// A partially written record is discarded.
+	file, _ := os.OpenFile(driver.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"op":"del","id":`)
+	file.Close()
+
+	restarted := &FileDriver{Path: driver.Path, PopInterval: time.Millisecond}
+	defer restarted.Close()
+	info, err := restarted.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, QueueInfo{Waiting: 1, Delayed: 2}, info)
+	msg, err := restarted.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "baz", msg.UniqueId)
+}
+
+func TestFileDriver_timeout(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpFileDriver(t)
+	defer driver.Close()
+
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "foo", HandleTimeout: time.Millisecond}, 0))
+	msg, _ := driver.Pop(ctx)
+	assert.ErrorIs(t, driver.Extend(ctx, &PersistedEvent{}, time.Second), ErrNotFound)
+	time.Sleep(2 * time.Millisecond)
+	driver.Pop(ctx)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Timeout: 1}, info)
+	assert.ErrorIs(t, driver.Extend(ctx, msg, time.Second), ErrNotFound)
+
+	n, _ := driver.Reload(ctx, "timeout")
+	assert.Equal(t, int64(1), n)
+	msg, _ = driver.Pop(ctx)
+	assert.NoError(t, driver.Release(ctx, msg))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Waiting: 1}, info)
+}
+
+func TestFileDriver_corrupt(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpFileDriver(t)
+	defer driver.Close()
+
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "foo", HandleTimeout: time.Hour}, 0))
+	driver.lock.Lock()
+	assert.NoError(t, driver.write(fileRecord{Op: "put", ID: 0, Channel: channelWaiting, AvailableAt: time.Now(), Payload: []byte("corrupt")}))
+	driver.lock.Unlock()
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", msg.UniqueId)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Failed: 1}, info)
+}
+
+func TestFileDriver_compact(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpFileDriver(t)
+	defer driver.Close()
+
+	for i := 0; i < compactThreshold; i++ {
+		assert.NoError(t, driver.Push(ctx, &PersistedEvent{HandleTimeout: time.Hour}, 0))
+		msg, _ := driver.Pop(ctx)
+		assert.NoError(t, driver.Ack(ctx, msg))
+	}
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "foo", HandleTimeout: time.Hour}, 0))
+	// Without compaction, there would be 3 records for each message.
+	data, _ := ioutil.ReadFile(driver.Path)
+	assert.LessOrEqual(t, bytes.Count(data, []byte("\n")), compactThreshold+1)
+
+	restarted := &FileDriver{Path: driver.Path}
+	defer restarted.Close()
+	info, _ := restarted.Info(ctx)
+	assert.Equal(t, QueueInfo{Waiting: 1}, info)
+}
@@ -0,0 +1,373 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DoNewsCode/core/otgorm"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormMessage is the table schema used by GormDriver.
+type gormMessage struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	Queue       string    `gorm:"size:191;index:idx_queue_messages_lookup,priority:1"`
+	Channel     string    `gorm:"size:16;index:idx_queue_messages_lookup,priority:2"`
+	AvailableAt time.Time `gorm:"index:idx_queue_messages_lookup,priority:3"`
+	UniqueId    string    `gorm:"size:191;index"`
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+// TableName implements gorm's schema.Tabler.
+func (gormMessage) TableName() string {
+	return "queue_messages"
+}
+
+// GormMigrations returns the database migrations needed for GormDriver.
+func GormMigrations(connection string) []*otgorm.Migration {
+	return []*otgorm.Migration{
+		{
+			ID:         "202104080100",
+			Connection: connection,
+			Migrate: func(db *gorm.DB) error {
+				return db.AutoMigrate(&gormMessage{})
+			},
+			Rollback: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&gormMessage{})
+			},
+		},
+	}
+}
+
+// GormDriver is a queue driver backed by relational databases. It is useful when
+// a redis instance is not available. Messages from all channels are stored in
+// the queue_messages table, which can be created by GormMigrations. Multiple
+// consumers can pop safely as rows are locked with "SELECT ... FOR UPDATE SKIP LOCKED".
+// Note SKIP LOCKED requires MySQL 8.0+. SQLite doesn't support row locking at
+// all, so it should only be used for testing.
+//
+// The channel names accepted by Reload and Flush are the plain names "failed",
+// "timeout", etc.
+type GormDriver struct {
+	DB          *gorm.DB      // DB is the database connection. The queue_messages table must be migrated beforehand.
+	Queue       string        // Queue is the name of the queue. Many queues can share the same table.
+	PopInterval time.Duration // PopInterval is the polling interval when no message is available.
+	Packer      Packer        // Packer describes how to save the message in wire format
+	Logger      log.Logger    // Logger is an optional logger. By default a noop logger is used
+
+	lock          sync.Mutex
+	defaultLoaded bool
+	reserved      map[*PersistedEvent]uint64
+}
+
+// Push pushes the message onto the queue. It is possible to specify a time delay. If so the message
+// will be read after the delay. Use zero value if a delay is not needed.
+func (g *GormDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	g.populateDefaults()
+	data, err := g.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	row := gormMessage{
+		Queue:       g.Queue,
+		Channel:     channelWaiting,
+		AvailableAt: time.Now(),
+		UniqueId:    message.UniqueId,
+		Payload:     data,
+	}
+	if delay > 0 {
+		row.Channel = channelDelayed
+		row.AvailableAt = row.AvailableAt.Add(delay)
+	}
+	if err := g.DB.WithContext(ctx).Create(&row).Error; err != nil {
+		return errors.Wrap(err, "failed to insert while pushing")
+	}
+	return nil
+}
+
+// Pop pops the message out of the queue. The database is polled every
+// PopInterval, so Pop blocks at most PopInterval before returning ErrEmpty.
+func (g *GormDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
+	g.populateDefaults()
+	now := time.Now()
+	if err := g.move(ctx, channelDelayed, channelWaiting, now); err != nil {
+		return nil, err
+	}
+	if err := g.move(ctx, channelReserved, channelTimeout, now); err != nil {
+		return nil, err
+	}
+
+	var (
+		rows    []gormMessage
+		message PersistedEvent
+		corrupt error
+	)
+	err := g.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("queue = ? AND channel = ?", g.Queue, channelWaiting).
+			Order("id").
+			Limit(1).
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return ErrEmpty
+		}
+		if err := g.Packer.Unmarshal(rows[0].Payload, &message); err != nil {
+			// The row would be popped again and again. It is moved out of the way.
+			corrupt = errors.Wrapf(err, "failed to decompress message %d, moving to the failed channel", rows[0].ID)
+			return tx.Model(&rows[0]).Update("channel", channelFailed).Error
+		}
+		return tx.Model(&rows[0]).Updates(map[string]interface{}{
+			"channel":      channelReserved,
+			"available_at": now.Add(message.HandleTimeout),
+		}).Error
+	})
+	if errors.Is(err, ErrEmpty) {
+		select {
+		case <-time.After(g.PopInterval):
+			return nil, ErrEmpty
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reserve message while popping")
+	}
+	if corrupt != nil {
+		_ = level.Warn(g.Logger).Log("err", corrupt)
+		return nil, ErrEmpty
+	}
+
+	g.lock.Lock()
+	g.reserved[&message] = rows[0].ID
+	g.lock.Unlock()
+
+	return &message, nil
+}
+
+// Ack acknowledges a message has been processed.
+func (g *GormDriver) Ack(ctx context.Context, message *PersistedEvent) error {
+	g.populateDefaults()
+	id, err := g.release(message)
+	if err != nil {
+		return err
+	}
+	if err := g.DB.WithContext(ctx).Delete(&gormMessage{}, id).Error; err != nil {
+		return errors.Wrap(err, "failed to delete while acknowledging message")
+	}
+	return nil
+}
+
+// Fail marks a message has failed.
+func (g *GormDriver) Fail(ctx context.Context, message *PersistedEvent) error {
+	g.populateDefaults()
+	id, err := g.release(message)
+	if err != nil {
+		return err
+	}
+	message.Attempts++
+	data, err := g.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	err = g.DB.WithContext(ctx).Model(&gormMessage{ID: id}).Updates(map[string]interface{}{
+		"channel": channelFailed,
+		"payload": data,
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to update while failing message")
+	}
+	return nil
+}
+
+// Release puts a reserved message back onto the waiting channel right away, without counting an attempt. It
+// implements Releaser.
+func (g *GormDriver) Release(ctx context.Context, message *PersistedEvent) error {
+	g.populateDefaults()
+	id, err := g.release(message)
+	if err != nil {
+		return err
+	}
+	err = g.DB.WithContext(ctx).
+		Model(&gormMessage{}).
+		Where("id = ? AND channel = ?", id, channelReserved).
+		Updates(map[string]interface{}{
+			"channel":      channelWaiting,
+			"available_at": time.Now(),
+		}).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to update while releasing message")
+	}
+	return nil
+}
+
+// Extend postpones the timeout of a reserved message. It implements Extender.
+func (g *GormDriver) Extend(ctx context.Context, message *PersistedEvent, timeout time.Duration) error {
+	g.populateDefaults()
+	g.lock.Lock()
+	id, ok := g.reserved[message]
+	g.lock.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	result := g.DB.WithContext(ctx).
+		Model(&gormMessage{}).
+		Where("id = ? AND channel = ?", id, channelReserved).
+		Update("available_at", time.Now().Add(timeout))
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to update while extending reservation")
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reload put failed/timeout message back to the Waiting queue. If the temporary outage have been cleared,
+// messages can be tried again via Reload. Reload is not a normal retry.
+// It similarly gives otherwise dead messages one more chance,
+// but this chance is not subject to the limit of MaxAttempts, nor does it reset the number of time attempted.
+func (g *GormDriver) Reload(ctx context.Context, channel string) (int64, error) {
+	g.populateDefaults()
+	if channel != channelFailed && channel != channelTimeout {
+		return 0, fmt.Errorf("reloading %s is not allowed", channel)
+	}
+	result := g.DB.WithContext(ctx).
+		Model(&gormMessage{}).
+		Where("queue = ? AND channel = ?", g.Queue, channel).
+		Updates(map[string]interface{}{
+			"channel":      channelWaiting,
+			"available_at": time.Now(),
+		})
+	if result.Error != nil {
+		return 0, errors.Wrapf(result.Error, "failed to update %s while reloading", channel)
+	}
+	return result.RowsAffected, nil
+}
+
+// Flush flushes a queue of choice by deleting all its data. Use with caution.
+func (g *GormDriver) Flush(ctx context.Context, channel string) error {
+	g.populateDefaults()
+	err := g.DB.WithContext(ctx).
+		Where("queue = ? AND channel = ?", g.Queue, channel).
+		Delete(&gormMessage{}).Error
+	if err != nil {
+		return errors.Wrapf(err, "failed to flush %s", channel)
+	}
+	return nil
+}
+
+// Info lists QueueInfo by counting rows in each channel. Useful for metrics and monitor.
+func (g *GormDriver) Info(ctx context.Context) (QueueInfo, error) {
+	g.populateDefaults()
+	var (
+		info   QueueInfo
+		counts []struct {
+			Channel string
+			Count   int64
+		}
+	)
+	err := g.DB.WithContext(ctx).
+		Model(&gormMessage{}).
+		Select("channel, count(*) as count").
+		Where("queue = ?", g.Queue).
+		Group("channel").
+		Scan(&counts).Error
+	if err != nil {
+		return info, errors.Wrap(err, "failed to collect queue info")
+	}
+	for _, c := range counts {
+		switch c.Channel {
+		case channelWaiting:
+			info.Waiting = c.Count
+		case channelDelayed:
+			info.Delayed = c.Count
+		case channelTimeout:
+			info.Timeout = c.Count
+		case channelFailed:
+			info.Failed = c.Count
+		}
+	}
+	return info, nil
+}
+
+// Retry put the message back onto the delayed queue. The message will be tried after a period of time specified
+// by Backoff. Note: if one listener failed, all listeners for this event will have to be retried. Make sure
+// your listeners are idempotent as always.
+func (g *GormDriver) Retry(ctx context.Context, message *PersistedEvent) error {
+	g.populateDefaults()
+	id, err := g.release(message)
+	if err != nil {
+		return err
+	}
+	delay := time.Now().Add(nextBackoff(message))
+	message.Attempts++
+	data, err := g.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	err = g.DB.WithContext(ctx).Model(&gormMessage{ID: id}).Updates(map[string]interface{}{
+		"channel":      channelDelayed,
+		"available_at": delay,
+		"payload":      data,
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to update while retrying")
+	}
+	return nil
+}
+
+// release forgets a reserved message and returns its row id.
+func (g *GormDriver) release(message *PersistedEvent) (uint64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	id, ok := g.reserved[message]
+	if !ok {
+		return 0, fmt.Errorf("message %s is not reserved by this driver", message.UniqueId)
+	}
+	delete(g.reserved, message)
+	return id, nil
+}
+
+func (g *GormDriver) move(ctx context.Context, from string, to string, now time.Time) error {
+	err := g.DB.WithContext(ctx).
+		Model(&gormMessage{}).
+		Where("queue = ? AND channel = ? AND available_at <= ?", g.Queue, from, now).
+		Update("channel", to).Error
+	if err != nil {
+		return errors.Wrap(err, "move failed")
+	}
+	return nil
+}
+
+func (g *GormDriver) populateDefaults() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.defaultLoaded {
+		return
+	}
+	if g.Packer == nil {
+		g.Packer = packer{}
+	}
+	if g.Logger == nil {
+		g.Logger = log.NewNopLogger()
+	}
+	if g.Queue == "" {
+		g.Queue = "default"
+	}
+	if g.PopInterval == time.Duration(0) {
+		g.PopInterval = time.Second
+	}
+	g.reserved = make(map[*PersistedEvent]uint64)
+	g.defaultLoaded = true
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setUpGormDriver(t *testing.T) *GormDriver {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	for _, migration := range GormMigrations("default") {
+		assert.NoError(t, migration.Migrate(db))
+	}
+	return &GormDriver{DB: db, Queue: "test", PopInterval: time.Millisecond}
+}
+
+func TestGormDriver_lifecycle(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpGormDriver(t)
+
+	err := driver.Push(ctx, &PersistedEvent{Key: "foo", HandleTimeout: time.Hour}, 0)
+	assert.NoError(t, err)
+	err = driver.Push(ctx, &PersistedEvent{Key: "bar", HandleTimeout: time.Hour}, time.Hour)
+	assert.NoError(t, err)
+
+	info, err := driver.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, QueueInfo{Waiting: 1, Delayed: 1}, info)
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", msg.Key)
+
+	_, err = driver.Pop(ctx)
+	assert.ErrorIs(t, err, ErrEmpty)
+
+	assert.NoError(t, driver.Fail(ctx, msg))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, int64(1), info.Failed)
+
+	num, err := driver.Reload(ctx, "failed")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), num)
+
+	msg, err = driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, msg.Attempts)
+	assert.NoError(t, driver.Ack(ctx, msg))
+
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Delayed: 1}, info)
+
+	assert.NoError(t, driver.Flush(ctx, "delayed"))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, QueueInfo{}, info)
+}
+
+func TestGormDriver_Retry(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpGormDriver(t)
+
+	err := driver.Push(ctx, &PersistedEvent{Key: "foo", HandleTimeout: time.Hour, Attempts: 1}, 0)
+	assert.NoError(t, err)
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, driver.Retry(ctx, msg))
+	assert.Equal(t, 2, msg.Attempts)
+	assert.GreaterOrEqual(t, int64(msg.Backoff), int64(time.Second))
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Delayed: 1}, info)
+
+	assert.Error(t, driver.Ack(ctx, msg), "retried message should no longer be reserved")
+}
+
+func TestGormDriver_timeout(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpGormDriver(t)
+
+	err := driver.Push(ctx, &PersistedEvent{Key: "foo", HandleTimeout: time.Millisecond}, 0)
+	assert.NoError(t, err)
+	_, err = driver.Pop(ctx)
+	assert.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = driver.Pop(ctx)
+	assert.ErrorIs(t, err, ErrEmpty)
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Timeout: 1}, info)
+
+	num, err := driver.Reload(ctx, "timeout")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), num)
+
+	_, err = driver.Reload(ctx, "waiting")
+	assert.Error(t, err)
+}
+
+func TestGormDriver_Extend(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpGormDriver(t)
+
+	err := driver.Push(ctx, &PersistedEvent{Key: "foo", HandleTimeout: 10 * time.Millisecond}, 0)
+	assert.NoError(t, err)
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, driver.Extend(ctx, msg, time.Hour))
+	time.Sleep(20 * time.Millisecond)
+	_, err = driver.Pop(ctx)
+	assert.ErrorIs(t, err, ErrEmpty)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{}, info)
+
+	assert.NoError(t, driver.Ack(ctx, msg))
+	assert.ErrorIs(t, driver.Extend(ctx, msg, time.Hour), ErrNotFound)
+}
+
+func TestGormDriver_corrupt(t *testing.T) {
+	ctx := context.Background()
+	driver := setUpGormDriver(t)
+	driver.populateDefaults()
+	assert.NoError(t, driver.DB.Create(&gormMessage{Queue: "test", Channel: channelWaiting, AvailableAt: time.Now(), Payload: []byte("corrupt")}).Error)
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{Key: "foo", HandleTimeout: time.Hour}, 0))
+
+	_, err := driver.Pop(ctx)
+	assert.ErrorIs(t, err, ErrEmpty)
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", msg.Key)
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, int64(1), info.Failed)
+}
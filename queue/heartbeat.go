@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ErrNoHeartbeat means the heartbeat can't be sent, either because the context is not passed from the queue, or
+// the driver doesn't implement Extender.
+var ErrNoHeartbeat = errors.New("heartbeat is not available")
+
+type leaseKey struct{}
+
+// Heartbeat tells the queue that the handler of a persisted event is still making progress. The message stays
+// reserved for another HandleTimeout from now, and the deadline of the handler context is postponed accordingly.
+// Handlers running longer than the HandleTimeout should call it periodically:
+//
+//  for _, row := range rows {
+//    export(row)
+//    if err := queue.Heartbeat(ctx); err != nil {
+//      return err
+//    }
+//  }
+//
+// If the message has timed out already, it may be handled by someone else. In that case an error is returned,
+// and the handler had better stop.
+func Heartbeat(ctx context.Context) error {
+	l, ok := ctx.Value(leaseKey{}).(*lease)
+	if !ok {
+		return ErrNoHeartbeat
+	}
+	return l.extend(ctx)
+}
+
+// lease keeps track of the reservation of the message being handled.
+type lease struct {
+	driver  Driver
+	message *PersistedEvent
+
+	lock     sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+	expired  bool
+}
+
+// newLease returns a context which is canceled once the HandleTimeout of the message has passed since the last
+// heartbeat.
+func newLease(parent context.Context, driver Driver, message *PersistedEvent) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	l := &lease{driver: driver, message: message, deadline: time.Now().Add(message.HandleTimeout)}
+	l.timer = time.AfterFunc(message.HandleTimeout, func() {
+		l.lock.Lock()
+		l.expired = true
+		l.lock.Unlock()
+		cancel()
+	})
+	leased := leaseContext{Context: context.WithValue(ctx, leaseKey{}, l), lease: l}
+	return leased, func() {
+		l.timer.Stop()
+		cancel()
+	}
+}
+
+// keepReserved extends the reservation of the message every half of its HandleTimeout, until the context is done.
+func keepReserved(ctx context.Context, extender Extender, message *PersistedEvent, logger log.Logger) {
+	if message.HandleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(message.HandleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := extender.Extend(ctx, message, message.HandleTimeout); err != nil && ctx.Err() == nil {
+				_ = level.Warn(logger).Log("err", errors.Wrapf(err, "failed to extend the reservation of event %s", message.Key))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *lease) extend(ctx context.Context) error {
+	extender, ok := l.driver.(Extender)
+	if !ok {
+		return ErrNoHeartbeat
+	}
+	if l.isExpired() {
+		return context.DeadlineExceeded
+	}
+	if err := extender.Extend(ctx, l.message, l.message.HandleTimeout); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.expired || !l.timer.Stop() {
+		return context.DeadlineExceeded
+	}
+	l.timer.Reset(l.message.HandleTimeout)
+	l.deadline = time.Now().Add(l.message.HandleTimeout)
+	return nil
+}
+
+func (l *lease) isExpired() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.expired
+}
+
+// leaseContext reports the deadline of the lease, which moves on every heartbeat.
+type leaseContext struct {
+	context.Context
+	lease *lease
+}
+
+func (c leaseContext) Deadline() (time.Time, bool) {
+	c.lease.lock.Lock()
+	defer c.lease.lock.Unlock()
+	return c.lease.deadline, true
+}
+
+func (c leaseContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && c.lease.isExpired() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"container/heap"
 	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -50,15 +52,18 @@ func (pq *priorityQueue) Pop() interface{} {
 }
 
 // InProcessDriver is a test replacement for redis driver. It doesn't persist your event in any way,
-// so not suitable for production use.
+// so not suitable for production use. Waiting lanes are created on demand, as events with higher Priority arrive.
 type InProcessDriver struct {
 	popInterval time.Duration
 	mutex       sync.Mutex
 	delayed     *priorityQueue
-	waiting     chan *PersistedEvent
+	waiting     []chan *PersistedEvent
+	pops        uint64
 	reserved    map[*PersistedEvent]time.Time
 	failed      map[*PersistedEvent]struct{}
 	timeout     map[*PersistedEvent]struct{}
+	unique      map[string]*PersistedEvent
+	replaced    map[*PersistedEvent]struct{}
 }
 
 // NewInProcessDriverWithPopInterval creates an *InProcessDriver for testing
@@ -68,9 +73,11 @@ func NewInProcessDriver() *InProcessDriver {
 		popInterval: time.Second,
 		delayed:     &delayed,
 		reserved:    make(map[*PersistedEvent]time.Time),
-		waiting:     make(chan *PersistedEvent, 1000),
+		waiting:     []chan *PersistedEvent{make(chan *PersistedEvent, 1000)},
 		failed:      make(map[*PersistedEvent]struct{}),
 		timeout:     make(map[*PersistedEvent]struct{}),
+		unique:      make(map[string]*PersistedEvent),
+		replaced:    make(map[*PersistedEvent]struct{}),
 	}
 }
 
@@ -81,15 +88,20 @@ func NewInProcessDriverWithPopInterval(duration time.Duration) *InProcessDriver
 		popInterval: duration,
 		delayed:     &delayed,
 		reserved:    make(map[*PersistedEvent]time.Time),
-		waiting:     make(chan *PersistedEvent, 1000),
+		waiting:     []chan *PersistedEvent{make(chan *PersistedEvent, 1000)},
 		failed:      make(map[*PersistedEvent]struct{}),
 		timeout:     make(map[*PersistedEvent]struct{}),
+		unique:      make(map[string]*PersistedEvent),
+		replaced:    make(map[*PersistedEvent]struct{}),
 	}
 }
 
 func (i *InProcessDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	if message.UniquePolicy != "" && !i.claimUnique(message) {
+		return ErrDuplicate
+	}
+	i.mutex.Lock()
 	if delay > 0 {
-		i.mutex.Lock()
 		heap.Push(i.delayed, &item{
 			event:    message,
 			priority: time.Now().Add(delay),
@@ -97,8 +109,10 @@ func (i *InProcessDriver) Push(ctx context.Context, message *PersistedEvent, del
 		i.mutex.Unlock()
 		return nil
 	}
+	lane := i.lane(message.Priority)
+	i.mutex.Unlock()
 	select {
-	case i.waiting <- message:
+	case lane <- message:
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
@@ -116,23 +130,38 @@ func (i *InProcessDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
 			heap.Push(i.delayed, top)
 			break
 		}
-		i.waiting <- top.event
+		i.lane(top.event.Priority) <- top.event
 	}
 	for k := range i.reserved {
 		if i.reserved[k].Before(time.Now()) {
 			i.timeout[k] = struct{}{}
 			delete(i.reserved, k)
+			i.releaseUnique(k)
 		}
 	}
+	i.pops++
+	order := laneOrder(len(i.waiting), i.pops, defaultStarvationGuard)
+	lanes := make([]chan *PersistedEvent, len(order))
+	for k, n := range order {
+		lanes[k] = i.waiting[n]
+	}
 	i.mutex.Unlock()
-	select {
-	case message := <-i.waiting:
+	timer := time.NewTimer(i.popInterval)
+	defer timer.Stop()
+	for {
+		message, err := receive(ctx, lanes, timer.C)
+		if err != nil {
+			return nil, err
+		}
+		i.mutex.Lock()
+		if _, ok := i.replaced[message]; ok {
+			delete(i.replaced, message)
+			i.mutex.Unlock()
+			continue
+		}
 		i.reserved[message] = time.Now().Add(message.HandleTimeout)
+		i.mutex.Unlock()
 		return message, nil
-	case <-time.After(i.popInterval):
-		return nil, ErrEmpty
-	case <-ctx.Done():
-		return nil, ctx.Err()
 	}
 }
 
@@ -140,6 +169,7 @@ func (i *InProcessDriver) Ack(ctx context.Context, message *PersistedEvent) erro
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 	delete(i.reserved, message)
+	i.releaseUnique(message)
 	return nil
 }
 
@@ -147,10 +177,34 @@ func (i *InProcessDriver) Fail(ctx context.Context, message *PersistedEvent) err
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 	delete(i.reserved, message)
+	i.releaseUnique(message)
 	i.failed[message] = struct{}{}
 	return nil
 }
 
+// Release puts a reserved message back onto the waiting channel. It implements Releaser.
+func (i *InProcessDriver) Release(ctx context.Context, message *PersistedEvent) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if _, ok := i.reserved[message]; !ok {
+		return nil
+	}
+	delete(i.reserved, message)
+	i.lane(message.Priority) <- message
+	return nil
+}
+
+// Extend postpones the timeout of a reserved message. It implements Extender.
+func (i *InProcessDriver) Extend(ctx context.Context, message *PersistedEvent, timeout time.Duration) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if _, ok := i.reserved[message]; !ok {
+		return ErrNotFound
+	}
+	i.reserved[message] = time.Now().Add(timeout)
+	return nil
+}
+
 func (i *InProcessDriver) Reload(ctx context.Context, channel string) (int64, error) {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
@@ -158,7 +212,7 @@ func (i *InProcessDriver) Reload(ctx context.Context, channel string) (int64, er
 	if channel == "failed" {
 		for k := range i.failed {
 			delete(i.failed, k)
-			i.waiting <- k
+			i.lane(k.Priority) <- k
 			j++
 		}
 		return j, nil
@@ -166,7 +220,7 @@ func (i *InProcessDriver) Reload(ctx context.Context, channel string) (int64, er
 	if channel == "timeout" {
 		for k := range i.timeout {
 			delete(i.timeout, k)
-			i.waiting <- k
+			i.lane(k.Priority) <- k
 			j++
 		}
 		return j, nil
@@ -189,22 +243,188 @@ func (i *InProcessDriver) Flush(ctx context.Context, channel string) error {
 func (i *InProcessDriver) Info(ctx context.Context) (QueueInfo, error) {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
-	return QueueInfo{
-		Waiting: int64(len(i.waiting)),
+	info := QueueInfo{
+		Waiting: -int64(len(i.replaced)),
 		Delayed: int64(len(*i.delayed)),
 		Timeout: int64(len(i.timeout)),
 		Failed:  int64(len(i.failed)),
-	}, nil
+	}
+	for _, lane := range i.waiting {
+		info.Waiting += int64(len(lane))
+		if len(i.waiting) > 1 {
+			info.Lanes = append(info.Lanes, int64(len(lane)))
+		}
+	}
+	return info, nil
 }
 
 func (i *InProcessDriver) Retry(ctx context.Context, message *PersistedEvent) error {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 	delete(i.reserved, message)
-	newBackOff := getRetryDuration(message.Backoff)
+	delay := time.Now().Add(nextBackoff(message))
+	message.Attempts++
 	heap.Push(i.delayed, &item{
 		event:    message,
-		priority: time.Now().Add(newBackOff),
+		priority: delay,
 	})
 	return nil
 }
+
+// List returns at most limit messages from the channel, skipping the first offset ones. Messages in the failed and
+// timeout channel are sorted by UniqueId, while messages in the delayed channel are sorted by their due time.
+// The waiting channel can not be listed. List implements Inspector.
+func (i *InProcessDriver) List(ctx context.Context, channel string, offset, limit int64) ([]*PersistedEvent, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	var messages []*PersistedEvent
+	switch channel {
+	case channelFailed, channelTimeout:
+		for k := range i.setOf(channel) {
+			messages = append(messages, k)
+		}
+		sort.Slice(messages, func(a, b int) bool {
+			return messages[a].UniqueId < messages[b].UniqueId
+		})
+	case channelDelayed:
+		items := make([]*item, len(*i.delayed))
+		copy(items, *i.delayed)
+		sort.Slice(items, func(a, b int) bool {
+			return items[a].priority.Before(items[b].priority)
+		})
+		for _, it := range items {
+			messages = append(messages, it.event)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported channel %s", channel)
+	}
+	if offset >= int64(len(messages)) {
+		return nil, nil
+	}
+	messages = messages[offset:]
+	if limit < int64(len(messages)) {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// Remove deletes the message with the given UniqueId from the channel and returns it. It implements Inspector.
+func (i *InProcessDriver) Remove(ctx context.Context, channel string, uniqueId string) (*PersistedEvent, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	switch channel {
+	case channelFailed, channelTimeout:
+		set := i.setOf(channel)
+		for k := range set {
+			if k.UniqueId == uniqueId {
+				delete(set, k)
+				return k, nil
+			}
+		}
+	case channelDelayed:
+		for _, it := range *i.delayed {
+			if it.event.UniqueId == uniqueId {
+				heap.Remove(i.delayed, it.index)
+				i.releaseUnique(it.event)
+				return it.event, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported channel %s", channel)
+	}
+	return nil, ErrNotFound
+}
+
+func (i *InProcessDriver) setOf(channel string) map[*PersistedEvent]struct{} {
+	if channel == channelFailed {
+		return i.failed
+	}
+	return i.timeout
+}
+
+// claimUnique indexes the message by its UniqueId. It returns false if the message should be dropped as a duplicate.
+func (i *InProcessDriver) claimUnique(message *PersistedEvent) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	existing, ok := i.unique[message.UniqueId]
+	if ok && message.UniquePolicy == DropDuplicate {
+		return false
+	}
+	if ok {
+		i.evict(existing)
+	}
+	i.unique[message.UniqueId] = message
+	return true
+}
+
+// evict removes a replaced message from the queue. Reserved messages are left alone, as they are being processed.
+// Waiting messages can't be taken out of the channel, so they are marked and skipped by Pop instead.
+func (i *InProcessDriver) evict(message *PersistedEvent) {
+	if _, ok := i.reserved[message]; ok {
+		return
+	}
+	for _, it := range *i.delayed {
+		if it.event == message {
+			heap.Remove(i.delayed, it.index)
+			return
+		}
+	}
+	i.replaced[message] = struct{}{}
+}
+
+// releaseUnique removes the message from the index, unless it has been replaced by another one.
+func (i *InProcessDriver) releaseUnique(message *PersistedEvent) {
+	if message.UniquePolicy == "" || i.unique[message.UniqueId] != message {
+		return
+	}
+	delete(i.unique, message.UniqueId)
+}
+
+// lane returns the waiting lane for the given priority, creating it if needed. The caller must hold the mutex.
+func (i *InProcessDriver) lane(priority int) chan *PersistedEvent {
+	if priority < 0 {
+		priority = 0
+	}
+	for len(i.waiting) <= priority {
+		i.waiting = append(i.waiting, make(chan *PersistedEvent, 1000))
+	}
+	return i.waiting[priority]
+}
+
+// receive takes a message from the first non-empty lane, or blocks until any of the lanes has one.
+func receive(ctx context.Context, lanes []chan *PersistedEvent, timeout <-chan time.Time) (*PersistedEvent, error) {
+	for _, lane := range lanes {
+		select {
+		case message := <-lane:
+			return message, nil
+		default:
+		}
+	}
+	if len(lanes) == 1 {
+		select {
+		case message := <-lanes[0]:
+			return message, nil
+		case <-timeout:
+			return nil, ErrEmpty
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	cases := make([]reflect.SelectCase, 0, len(lanes)+2)
+	for _, lane := range lanes {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(lane)})
+	}
+	cases = append(
+		cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timeout)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	)
+	chosen, value, _ := reflect.Select(cases)
+	switch chosen {
+	case len(lanes):
+		return nil, ErrEmpty
+	case len(lanes) + 1:
+		return nil, ctx.Err()
+	}
+	return value.Interface().(*PersistedEvent), nil
+}
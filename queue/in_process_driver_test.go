@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInProcessDriver_unique(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("drop", func(t *testing.T) {
+		driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+		first := &PersistedEvent{UniqueId: "foo", Key: "first", UniquePolicy: DropDuplicate, HandleTimeout: time.Hour}
+		second := &PersistedEvent{UniqueId: "foo", Key: "second", UniquePolicy: DropDuplicate, HandleTimeout: time.Hour}
+		assert.NoError(t, driver.Push(ctx, first, 0))
+		assert.ErrorIs(t, driver.Push(ctx, second, 0), ErrDuplicate)
+		info, _ := driver.Info(ctx)
+		assert.Equal(t, int64(1), info.Waiting)
+
+		msg, err := driver.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "first", msg.Key)
+
+		// still reserved
+		assert.ErrorIs(t, driver.Push(ctx, second, 0), ErrDuplicate)
+		info, _ = driver.Info(ctx)
+		assert.Equal(t, int64(0), info.Waiting)
+
+		assert.NoError(t, driver.Ack(ctx, msg))
+		assert.NoError(t, driver.Push(ctx, second, 0))
+		info, _ = driver.Info(ctx)
+		assert.Equal(t, int64(1), info.Waiting)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+		first := &PersistedEvent{UniqueId: "foo", Key: "first", UniquePolicy: ReplaceDuplicate, HandleTimeout: time.Hour}
+		second := &PersistedEvent{UniqueId: "foo", Key: "second", UniquePolicy: ReplaceDuplicate, HandleTimeout: time.Hour}
+		third := &PersistedEvent{UniqueId: "foo", Key: "third", UniquePolicy: ReplaceDuplicate, HandleTimeout: time.Hour}
+		assert.NoError(t, driver.Push(ctx, first, 0))
+		assert.NoError(t, driver.Push(ctx, second, time.Hour))
+		assert.NoError(t, driver.Push(ctx, third, 0))
+		info, _ := driver.Info(ctx)
+		assert.Equal(t, QueueInfo{Waiting: 1}, info)
+
+		msg, err := driver.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "third", msg.Key)
+		_, err = driver.Pop(ctx)
+		assert.ErrorIs(t, err, ErrEmpty)
+	})
+}
+
+func TestInProcessDriver_priority(t *testing.T) {
+	ctx := context.Background()
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{Key: "low"}, 0))
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{Key: "high", Priority: 2}, 0))
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{Key: "medium", Priority: 1}, 0))
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Waiting: 3, Lanes: []int64{1, 1, 1}}, info)
+
+	for _, key := range []string{"high", "medium", "low"} {
+		msg, err := driver.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, key, msg.Key)
+	}
+}
+
+func TestLaneOrder(t *testing.T) {
+	assert.Equal(t, []int{0}, laneOrder(0, 1, 10))
+	assert.Equal(t, []int{2, 1, 0}, laneOrder(3, 1, 10))
+	assert.Equal(t, []int{1, 2, 0}, laneOrder(3, 10, 10))
+	assert.Equal(t, []int{0, 2, 1}, laneOrder(3, 20, 10))
+	assert.Equal(t, []int{2, 1, 0}, laneOrder(3, 20, -1))
+}
+
+func TestChannelConfig_Lane(t *testing.T) {
+	config := ChannelConfig{Waiting: "waiting", Lanes: 3}
+	assert.Equal(t, "waiting", config.Lane(-1))
+	assert.Equal(t, "waiting", config.Lane(0))
+	assert.Equal(t, "waiting:1", config.Lane(1))
+	assert.Equal(t, "waiting:2", config.Lane(5))
+	assert.Equal(t, "waiting", ChannelConfig{Waiting: "waiting"}.Lane(1))
+}
@@ -0,0 +1,551 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DoNewsCode/core/otkafka"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaDueHeader is the header key that records when a delayed message becomes available.
+const kafkaDueHeader = "x-queue-due"
+
+// KafkaDriver is a queue driver backed by kafka. Messages are popped by a
+// consumer group reader, and acknowledged by committing their offsets.
+//
+// Kafka has no native support for delayed messages. Delayed and retrying
+// messages are written to one of the delay topics, and background goroutines
+// move them back to the Waiting topic once they are due. There is a delay topic
+// for each of the DelayTiers, named after the Delayed topic and the tier, such
+// as "orders-retry-1m". A message goes to the smallest tier not shorter than its
+// delay, so that a long delay never holds up the shorter ones behind it. Delays
+// longer than the largest tier pass through its topic several times. Messages failed for good are
+// written to the Failed topic, also known as the dead letter topic. The
+// timeout channel is not supported, as uncommitted messages are redelivered by
+// kafka after a rebalance.
+//
+// Note offsets are committed per partition. When messages are processed in
+// parallel, committing a later message implicitly commits the earlier ones. If
+// the process crashes, an earlier message still in progress won't be
+// redelivered. Use a parallelism of 1, or enough partitions, if this is a
+// concern.
+type KafkaDriver struct {
+	WriterMaker   otkafka.WriterMaker // WriterMaker makes the kafka writer. The writer must not have a topic configured.
+	WriterName    string              // WriterName is the name passed to WriterMaker. By default "default" is used.
+	Reader        *kafka.Reader       // Reader consumes the Waiting topic. It must be a consumer group reader.
+	ChannelConfig ChannelConfig       // ChannelConfig holds the topic names. Only Waiting, Delayed and Failed are used.
+	PopTimeout    time.Duration       // PopTimeout is the maximum time the pop action will block.
+	DelayTiers    []time.Duration     // DelayTiers are the delays of the delay topics, in ascending order. By default 5s, 1m, 10m and 1h.
+	Packer        Packer              // Packer describes how to save the message in wire format
+	Logger        log.Logger          // Logger is an optional logger. By default a noop logger is used
+
+	lock           sync.Mutex
+	defaultLoaded  bool
+	pumping        bool
+	writer         *kafka.Writer
+	transport      *kafka.Transport
+	delayedReaders []*kafka.Reader
+	failedReader   *kafka.Reader
+	reserved       map[*PersistedEvent]kafka.Message
+}
+
+// defaultDelayTiers are the delays of the delay topics if DelayTiers is not set.
+var defaultDelayTiers = []time.Duration{5 * time.Second, time.Minute, 10 * time.Minute, time.Hour}
+
+// Push pushes the message onto the queue. It is possible to specify a time delay. If so the message
+// will be read after the delay. Use zero value if a delay is not needed.
+func (k *KafkaDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	if err := k.populateDefaults(); err != nil {
+		return err
+	}
+	if delay <= time.Duration(0) {
+		return k.write(ctx, k.ChannelConfig.Waiting, message, time.Time{})
+	}
+	return k.write(ctx, k.delayedTopic(k.tier(delay)), message, time.Now().Add(delay))
+}
+
+// Pop pops the message out of the queue. It blocks until a message is available or PopTimeout is reached.
+// The first call of Pop also starts moving due messages from the delay topics to the Waiting topic, until
+// the context is canceled.
+func (k *KafkaDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
+	if err := k.populateDefaults(); err != nil {
+		return nil, err
+	}
+	k.startPump(ctx)
+
+	popCtx, cancel := context.WithTimeout(ctx, k.PopTimeout)
+	defer cancel()
+	msg, err := k.Reader.FetchMessage(popCtx)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrEmpty
+		}
+		return nil, errors.Wrap(err, "failed to fetch message while popping")
+	}
+	var message PersistedEvent
+	if err := k.Packer.Unmarshal(msg.Value, &message); err != nil {
+		// The message would be fetched again and again. It is moved to the Failed topic instead.
+		_ = level.Warn(k.Logger).Log("err", errors.Wrapf(
+			err, "failed to decompress message at offset %d of partition %d, moving to %s", msg.Offset, msg.Partition, k.ChannelConfig.Failed,
+		))
+		err := k.writer.WriteMessages(ctx, kafka.Message{
+			Topic:   k.ChannelConfig.Failed,
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: msg.Headers,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to move undecodable message")
+		}
+		if err := k.Reader.CommitMessages(ctx, msg); err != nil {
+			return nil, errors.Wrap(err, "failed to commit undecodable message")
+		}
+		return nil, ErrEmpty
+	}
+
+	k.lock.Lock()
+	k.reserved[&message] = msg
+	k.lock.Unlock()
+
+	return &message, nil
+}
+
+// Ack acknowledges a message has been processed by committing its offset.
+func (k *KafkaDriver) Ack(ctx context.Context, message *PersistedEvent) error {
+	if err := k.populateDefaults(); err != nil {
+		return err
+	}
+	msg, err := k.release(message)
+	if err != nil {
+		return err
+	}
+	if err := k.Reader.CommitMessages(ctx, msg); err != nil {
+		return errors.Wrap(err, "failed to commit while acknowledging message")
+	}
+	return nil
+}
+
+// Fail marks a message has failed by moving it to the dead letter topic.
+func (k *KafkaDriver) Fail(ctx context.Context, message *PersistedEvent) error {
+	if err := k.populateDefaults(); err != nil {
+		return err
+	}
+	msg, err := k.release(message)
+	if err != nil {
+		return err
+	}
+	message.Attempts++
+	if err := k.write(ctx, k.ChannelConfig.Failed, message, time.Time{}); err != nil {
+		return errors.Wrap(err, "failed to write dead letter while failing message")
+	}
+	if err := k.Reader.CommitMessages(ctx, msg); err != nil {
+		return errors.Wrap(err, "failed to commit while failing message")
+	}
+	return nil
+}
+
+// Reload put failed messages back to the Waiting topic. If the temporary outage have been cleared,
+// messages can be tried again via Reload. Reload is not a normal retry.
+// It similarly gives otherwise dead messages one more chance,
+// but this chance is not subject to the limit of MaxAttempts, nor does it reset the number of time attempted.
+// Only the Failed topic can be reloaded.
+func (k *KafkaDriver) Reload(ctx context.Context, channel string) (int64, error) {
+	if err := k.populateDefaults(); err != nil {
+		return 0, err
+	}
+	if channel != k.ChannelConfig.Failed && channel != channelFailed {
+		return 0, fmt.Errorf("reloading %s is not allowed", channel)
+	}
+	return k.drain(ctx, k.failedReader, func(msg kafka.Message) error {
+		return k.writer.WriteMessages(ctx, kafka.Message{
+			Topic: k.ChannelConfig.Waiting,
+			Key:   msg.Key,
+			Value: msg.Value,
+		})
+	})
+}
+
+// Flush discards all messages in the Failed topic by committing their offsets. Kafka topics are append only,
+// so the data itself is left to the retention policy. Other topics can not be flushed.
+func (k *KafkaDriver) Flush(ctx context.Context, channel string) error {
+	if err := k.populateDefaults(); err != nil {
+		return err
+	}
+	if channel != k.ChannelConfig.Failed && channel != channelFailed {
+		return fmt.Errorf("flushing %s is not allowed", channel)
+	}
+	_, err := k.drain(ctx, k.failedReader, func(msg kafka.Message) error { return nil })
+	return err
+}
+
+// Info lists QueueInfo by calculating the consumer group lag of each topic. Useful for metrics and monitor.
+func (k *KafkaDriver) Info(ctx context.Context) (QueueInfo, error) {
+	var info QueueInfo
+	if err := k.populateDefaults(); err != nil {
+		return info, err
+	}
+	var err error
+	if info.Waiting, err = k.lag(ctx, k.ChannelConfig.Waiting); err != nil {
+		return info, errors.Wrap(err, "failed to collect queue info")
+	}
+	for i := range k.DelayTiers {
+		delayed, err := k.lag(ctx, k.delayedTopic(i))
+		if err != nil {
+			return info, errors.Wrap(err, "failed to collect queue info")
+		}
+		info.Delayed += delayed
+	}
+	if info.Failed, err = k.lag(ctx, k.ChannelConfig.Failed); err != nil {
+		return info, errors.Wrap(err, "failed to collect queue info")
+	}
+	return info, nil
+}
+
+// Retry put the message onto a delay topic. The message will be tried after a period of time specified
+// by Backoff. Note: if one listener failed, all listeners for this event will have to be retried. Make sure
+// your listeners are idempotent as always.
+func (k *KafkaDriver) Retry(ctx context.Context, message *PersistedEvent) error {
+	if err := k.populateDefaults(); err != nil {
+		return err
+	}
+	msg, err := k.release(message)
+	if err != nil {
+		return err
+	}
+	delay := nextBackoff(message)
+	message.Attempts++
+	if err := k.write(ctx, k.delayedTopic(k.tier(delay)), message, time.Now().Add(delay)); err != nil {
+		return errors.Wrap(err, "failed to write while retrying")
+	}
+	if err := k.Reader.CommitMessages(ctx, msg); err != nil {
+		return errors.Wrap(err, "failed to commit while retrying")
+	}
+	return nil
+}
+
+// Close closes the readers created by the driver. The Reader and the writer
+// from WriterMaker are not closed, as they are owned by the caller.
+func (k *KafkaDriver) Close() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if !k.defaultLoaded {
+		return nil
+	}
+	k.transport.CloseIdleConnections()
+	for _, reader := range k.delayedReaders {
+		if err := reader.Close(); err != nil {
+			return err
+		}
+	}
+	return k.failedReader.Close()
+}
+
+func (k *KafkaDriver) write(ctx context.Context, topic string, message *PersistedEvent, due time.Time) error {
+	data, err := k.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	msg := kafka.Message{
+		Topic: topic,
+		Key:   []byte(message.UniqueId),
+		Value: data,
+	}
+	if !due.IsZero() {
+		msg.Headers = []kafka.Header{{Key: kafkaDueHeader, Value: []byte(strconv.FormatInt(due.UnixNano(), 10))}}
+	}
+	if err := k.writer.WriteMessages(ctx, msg); err != nil {
+		return errors.Wrapf(err, "failed to write to %s", topic)
+	}
+	return nil
+}
+
+func (k *KafkaDriver) release(message *PersistedEvent) (kafka.Message, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	msg, ok := k.reserved[message]
+	if !ok {
+		return msg, fmt.Errorf("message %s is not reserved by this driver", message.UniqueId)
+	}
+	delete(k.reserved, message)
+	return msg, nil
+}
+
+// drain reads all messages currently available in the reader, calls fn with each
+// of them and commits. It stops when no message arrives within PopTimeout.
+func (k *KafkaDriver) drain(ctx context.Context, reader *kafka.Reader, fn func(msg kafka.Message) error) (int64, error) {
+	var count int64
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, k.PopTimeout)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				return count, nil
+			}
+			return count, errors.Wrapf(err, "failed to fetch from %s", reader.Config().Topic)
+		}
+		if err := fn(msg); err != nil {
+			return count, err
+		}
+		if err := reader.CommitMessages(ctx, msg); err != nil {
+			return count, errors.Wrapf(err, "failed to commit %s", reader.Config().Topic)
+		}
+		count++
+	}
+}
+
+func (k *KafkaDriver) startPump(ctx context.Context) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.pumping {
+		return
+	}
+	k.pumping = true
+	var wg sync.WaitGroup
+	wg.Add(len(k.DelayTiers))
+	for i := range k.DelayTiers {
+		go func(i int) {
+			defer wg.Done()
+			k.pump(ctx, i)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		k.lock.Lock()
+		k.pumping = false
+		k.lock.Unlock()
+	}()
+}
+
+// pump moves due messages from the delay topic of the i-th tier to the Waiting
+// topic. Messages in the topic are handled in order, but none of them waits more
+// than the tier before being moved on. Messages not yet due by then are written
+// to the delay topic matching the remaining delay.
+func (k *KafkaDriver) pump(ctx context.Context, i int) {
+	reader := k.delayedReaders[i]
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			_ = level.Warn(k.Logger).Log("err", errors.Wrap(err, "failed to fetch delayed message"))
+			if !sleep(ctx, k.PopTimeout) {
+				return
+			}
+			continue
+		}
+		wait := time.Until(dueTime(msg))
+		if wait > k.DelayTiers[i] {
+			wait = k.DelayTiers[i]
+		}
+		if !sleep(ctx, wait) {
+			return
+		}
+		next := kafka.Message{Topic: k.ChannelConfig.Waiting, Key: msg.Key, Value: msg.Value}
+		if remaining := time.Until(dueTime(msg)); remaining > 0 {
+			next.Topic = k.delayedTopic(k.tier(remaining))
+			next.Headers = msg.Headers
+		}
+		for {
+			err := k.writer.WriteMessages(ctx, next)
+			if err == nil {
+				break
+			}
+			_ = level.Warn(k.Logger).Log("err", errors.Wrap(err, "failed to move delayed message"))
+			if !sleep(ctx, k.PopTimeout) {
+				return
+			}
+		}
+		if err := reader.CommitMessages(ctx, msg); err != nil {
+			_ = level.Warn(k.Logger).Log("err", errors.Wrap(err, "failed to commit delayed message"))
+		}
+	}
+}
+
+// lag calculates how many messages in the topic are not yet committed by the consumer group.
+func (k *KafkaDriver) lag(ctx context.Context, topic string) (int64, error) {
+	config := k.Reader.Config()
+	client := &kafka.Client{Addr: kafka.TCP(config.Brokers...), Transport: k.transport}
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return 0, err
+	}
+	if len(metadata.Topics) == 0 || metadata.Topics[0].Error != nil {
+		return 0, nil
+	}
+	var (
+		partitions []int
+		requests   []kafka.OffsetRequest
+	)
+	for _, p := range metadata.Topics[0].Partitions {
+		partitions = append(partitions, p.ID)
+		requests = append(requests, kafka.FirstOffsetOf(p.ID), kafka.LastOffsetOf(p.ID))
+	}
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: config.GroupID,
+		Topics:  map[string][]int{topic: partitions},
+	})
+	if err != nil {
+		return 0, err
+	}
+	offsets, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var commits = make(map[int]int64)
+	for _, p := range committed.Topics[topic] {
+		commits[p.Partition] = p.CommittedOffset
+	}
+	var lag int64
+	for _, p := range offsets.Topics[topic] {
+		start, ok := commits[p.Partition]
+		if !ok || start < p.FirstOffset {
+			start = p.FirstOffset
+		}
+		lag += p.LastOffset - start
+	}
+	return lag, nil
+}
+
+func (k *KafkaDriver) populateDefaults() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.defaultLoaded {
+		return nil
+	}
+	if k.Reader == nil || k.Reader.Config().GroupID == "" {
+		return errors.New("kafka driver requires a consumer group reader")
+	}
+	if k.WriterMaker == nil {
+		return errors.New("kafka driver requires a writer maker")
+	}
+	if k.WriterName == "" {
+		k.WriterName = "default"
+	}
+	writer, err := k.WriterMaker.Make(k.WriterName)
+	if err != nil {
+		return errors.Wrap(err, "failed to make kafka writer")
+	}
+	if writer.Topic != "" {
+		return fmt.Errorf("kafka writer %s must not have a topic, as the driver writes to several topics", k.WriterName)
+	}
+	k.writer = writer
+	if k.Packer == nil {
+		k.Packer = packer{}
+	}
+	if k.Logger == nil {
+		k.Logger = log.NewNopLogger()
+	}
+	config := k.Reader.Config()
+	if k.ChannelConfig.Waiting == "" {
+		k.ChannelConfig.Waiting = config.Topic
+	}
+	if k.ChannelConfig.Delayed == "" {
+		k.ChannelConfig.Delayed = config.Topic + "-retry"
+	}
+	if k.ChannelConfig.Failed == "" {
+		k.ChannelConfig.Failed = config.Topic + "-dead-letter"
+	}
+	if k.PopTimeout == time.Duration(0) {
+		k.PopTimeout = time.Second
+	}
+	if len(k.DelayTiers) == 0 {
+		k.DelayTiers = defaultDelayTiers
+	}
+	for i := range k.DelayTiers {
+		k.delayedReaders = append(k.delayedReaders, kafka.NewReader(kafka.ReaderConfig{
+			Brokers: config.Brokers,
+			GroupID: config.GroupID,
+			Topic:   k.delayedTopic(i),
+			Dialer:  config.Dialer,
+		}))
+	}
+	k.failedReader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers: config.Brokers,
+		GroupID: config.GroupID,
+		Topic:   k.ChannelConfig.Failed,
+		Dialer:  config.Dialer,
+	})
+	k.transport = transportOf(config.Dialer)
+	k.reserved = make(map[*PersistedEvent]kafka.Message)
+	k.defaultLoaded = true
+	return nil
+}
+
+// tier returns the index of the smallest tier not shorter than the delay, or the
+// largest tier if the delay exceeds them all.
+func (k *KafkaDriver) tier(delay time.Duration) int {
+	for i, tier := range k.DelayTiers {
+		if delay <= tier {
+			return i
+		}
+	}
+	return len(k.DelayTiers) - 1
+}
+
+// delayedTopic returns the name of the delay topic of the i-th tier, such as "orders-retry-10m".
+func (k *KafkaDriver) delayedTopic(i int) string {
+	name := k.DelayTiers[i].String()
+	if strings.HasSuffix(name, "m0s") {
+		name = strings.TrimSuffix(name, "0s")
+	}
+	if strings.HasSuffix(name, "h0m") {
+		name = strings.TrimSuffix(name, "0m")
+	}
+	return k.ChannelConfig.Delayed + "-" + name
+}
+
+// transportOf returns the transport of kafka clients, with the same settings as the dialer, such as TLS and SASL.
+func transportOf(dialer *kafka.Dialer) *kafka.Transport {
+	if dialer == nil {
+		return &kafka.Transport{}
+	}
+	return &kafka.Transport{
+		Dial:        dialer.DialFunc,
+		DialTimeout: dialer.Timeout,
+		ClientID:    dialer.ClientID,
+		TLS:         dialer.TLS,
+		SASL:        dialer.SASLMechanism,
+	}
+}
+
+func dueTime(msg kafka.Message) time.Time {
+	for _, header := range msg.Headers {
+		if header.Key != kafkaDueHeader {
+			continue
+		}
+		nano, err := strconv.ParseInt(string(header.Value), 10, 64)
+		if err != nil {
+			break
+		}
+		return time.Unix(0, nano)
+	}
+	return time.Time{}
+}
+
+// sleep blocks for the duration given. It returns false if the context is canceled in the meantime.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
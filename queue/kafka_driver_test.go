@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type kafkaWriterMaker struct{}
+
+func (k kafkaWriterMaker) Make(name string) (*kafka.Writer, error) {
+	return &kafka.Writer{Addr: kafka.TCP(os.Getenv("KAFKA_ADDR")), BatchSize: 1}, nil
+}
+
+func TestKafkaDriver(t *testing.T) {
+	if os.Getenv("KAFKA_ADDR") == "" {
+		t.Skip("set env KAFKA_ADDR to run kafka driver tests")
+	}
+	ctx := context.Background()
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: []string{os.Getenv("KAFKA_ADDR")},
+		GroupID: "queue-test",
+		Topic:   "queue-test",
+		MaxWait: 100 * time.Millisecond,
+	})
+	defer reader.Close()
+	driver := &KafkaDriver{
+		WriterMaker: kafkaWriterMaker{},
+		Reader:      reader,
+		PopTimeout:  5 * time.Second,
+	}
+	defer driver.Close()
+
+	err := driver.Push(ctx, &PersistedEvent{UniqueId: "foo", Attempts: 1, MaxAttempts: 2}, 0)
+	assert.NoError(t, err)
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", msg.UniqueId)
+	assert.NoError(t, driver.Retry(ctx, msg))
+
+	msg, err = driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, msg.Attempts)
+	assert.NoError(t, driver.Fail(ctx, msg))
+
+	num, err := driver.Reload(ctx, driver.ChannelConfig.Failed)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), num)
+
+	msg, err = driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, driver.Ack(ctx, msg))
+
+	_, err = driver.Reload(ctx, driver.ChannelConfig.Waiting)
+	assert.Error(t, err)
+}
+
+func TestDueTime(t *testing.T) {
+	now := time.Now()
+	msg := kafka.Message{Headers: []kafka.Header{{Key: kafkaDueHeader, Value: []byte(strconv.FormatInt(now.UnixNano(), 10))}}}
+	assert.True(t, now.Equal(dueTime(msg)))
+	assert.True(t, dueTime(kafka.Message{}).IsZero())
+}
+
+func TestKafkaDriver_delayTiers(t *testing.T) {
+	if os.Getenv("KAFKA_ADDR") == "" {
+		t.Skip("set env KAFKA_ADDR to run kafka driver tests")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: []string{os.Getenv("KAFKA_ADDR")},
+		GroupID: "queue-test-tiers",
+		Topic:   "queue-test-tiers",
+		MaxWait: 100 * time.Millisecond,
+	})
+	defer reader.Close()
+	driver := &KafkaDriver{
+		WriterMaker: kafkaWriterMaker{},
+		Reader:      reader,
+		PopTimeout:  5 * time.Second,
+		DelayTiers:  []time.Duration{time.Second, time.Hour},
+	}
+	defer driver.Close()
+
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "long"}, 24*time.Hour))
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "short"}, time.Second))
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "short", msg.UniqueId)
+	assert.NoError(t, driver.Ack(ctx, msg))
+}
+
+func TestKafkaDriver_corrupt(t *testing.T) {
+	if os.Getenv("KAFKA_ADDR") == "" {
+		t.Skip("set env KAFKA_ADDR to run kafka driver tests")
+	}
+	ctx := context.Background()
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: []string{os.Getenv("KAFKA_ADDR")},
+		GroupID: "queue-test-corrupt",
+		Topic:   "queue-test-corrupt",
+		MaxWait: 100 * time.Millisecond,
+	})
+	defer reader.Close()
+	driver := &KafkaDriver{
+		WriterMaker: kafkaWriterMaker{},
+		Reader:      reader,
+		PopTimeout:  5 * time.Second,
+	}
+	defer driver.Close()
+
+	writer, _ := kafkaWriterMaker{}.Make("default")
+	assert.NoError(t, writer.WriteMessages(ctx, kafka.Message{Topic: "queue-test-corrupt", Value: []byte("corrupt")}))
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{UniqueId: "foo"}, 0))
+
+	_, err := driver.Pop(ctx)
+	assert.ErrorIs(t, err, ErrEmpty)
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", msg.UniqueId)
+	assert.NoError(t, driver.Ack(ctx, msg))
+}
+
+func TestTransportOf(t *testing.T) {
+	config := &tls.Config{}
+	transport := transportOf(&kafka.Dialer{ClientID: "foo", TLS: config, Timeout: time.Second})
+	assert.Equal(t, "foo", transport.ClientID)
+	assert.Same(t, config, transport.TLS)
+	assert.Equal(t, time.Second, transport.DialTimeout)
+	assert.NotNil(t, transportOf(nil))
+}
+
+func TestKafkaDriver_tier(t *testing.T) {
+	driver := &KafkaDriver{ChannelConfig: ChannelConfig{Delayed: "orders-retry"}, DelayTiers: defaultDelayTiers}
+	cases := []struct {
+		delay time.Duration
+		topic string
+	}{
+		{time.Millisecond, "orders-retry-5s"},
+		{5 * time.Second, "orders-retry-5s"},
+		{6 * time.Second, "orders-retry-1m"},
+		{10 * time.Minute, "orders-retry-10m"},
+		{11 * time.Minute, "orders-retry-1h"},
+		{24 * time.Hour, "orders-retry-1h"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.topic, driver.delayedTopic(driver.tier(c.delay)), c.delay.String())
+	}
+
+	driver.DelayTiers = []time.Duration{90 * time.Second, 150 * time.Minute}
+	assert.Equal(t, "orders-retry-1m30s", driver.delayedTopic(0))
+	assert.Equal(t, "orders-retry-2h30m", driver.delayedTopic(1))
+}
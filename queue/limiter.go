@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes how fast the events of a type can be consumed.
+type Limit struct {
+	// Concurrency is the maximum number of handlers running at the same time. Zero means no limit.
+	Concurrency int
+	// Rate is the number of handlers allowed to start per second. Zero means no limit.
+	Rate float64
+	// Burst is the maximum number of handlers allowed to start at once, if the Rate permits. By default, Burst is
+	// Rate rounded up.
+	Burst int
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return int(math.Ceil(l.Rate))
+}
+
+// Limiter restricts the consumption of persisted events by their type. See UseLimiter.
+type Limiter interface {
+	// Acquire blocks until the handler of the message is allowed to run, or the context is done. The release
+	// function must be called once the handler finishes.
+	Acquire(ctx context.Context, message *PersistedEvent) (release func(), err error)
+}
+
+// LocalLimiter is a Limiter that enforces Limits within the current process. If there are many consumers, the
+// actual limit is multiplied by the number of them. Use RedisLimiter if that is not desired.
+type LocalLimiter struct {
+	Limits map[string]Limit // Limits is keyed by the event type.
+
+	lock    sync.Mutex
+	buckets map[string]*localBucket
+}
+
+type localBucket struct {
+	limit  Limit
+	slots  chan struct{}
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Acquire implements Limiter.
+func (l *LocalLimiter) Acquire(ctx context.Context, message *PersistedEvent) (func(), error) {
+	bucket := l.bucket(message.Type())
+	if bucket == nil {
+		return func() {}, nil
+	}
+	if bucket.slots != nil {
+		select {
+		case bucket.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if bucket.slots != nil {
+			<-bucket.slots
+		}
+	}
+	if err := bucket.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+func (l *LocalLimiter) bucket(eventType string) *localBucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*localBucket)
+	}
+	if bucket, ok := l.buckets[eventType]; ok {
+		return bucket
+	}
+	limit, ok := l.Limits[eventType]
+	if !ok {
+		return nil
+	}
+	bucket := &localBucket{limit: limit, tokens: float64(limit.burst()), last: time.Now()}
+	if limit.Concurrency > 0 {
+		bucket.slots = make(chan struct{}, limit.Concurrency)
+	}
+	l.buckets[eventType] = bucket
+	return bucket
+}
+
+// wait takes a token from the bucket, waiting for it if necessary. Tokens can
+// be reserved in advance, in which case the balance goes negative.
+func (b *localBucket) wait(ctx context.Context) error {
+	if b.limit.Rate <= 0 {
+		return nil
+	}
+	b.lock.Lock()
+	now := time.Now()
+	b.tokens = math.Min(float64(b.limit.burst()), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+	b.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.lock.Lock()
+		b.tokens++
+		b.lock.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,131 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalLimiter_concurrency(t *testing.T) {
+	limiter := &LocalLimiter{Limits: map[string]Limit{"foo": {Concurrency: 2}}}
+	testLimiterConcurrency(t, limiter)
+}
+
+func TestRedisLimiter_concurrency(t *testing.T) {
+	limiter := &RedisLimiter{Prefix: "{RedisLimiterTest}", Limits: map[string]Limit{"foo": {Concurrency: 2}}, PollInterval: time.Millisecond}
+	testLimiterConcurrency(t, limiter)
+}
+
+func testLimiterConcurrency(t *testing.T, limiter Limiter) {
+	var (
+		wg      sync.WaitGroup
+		running int32
+		max     int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.Acquire(context.Background(), &PersistedEvent{Key: "foo", HandleTimeout: time.Minute})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), max)
+}
+
+func TestRedisLimiter_renewal(t *testing.T) {
+	limiter := &RedisLimiter{Prefix: "{RedisLimiterRenewalTest}", Limits: map[string]Limit{"foo": {Concurrency: 1}}, PollInterval: time.Millisecond}
+	message := &PersistedEvent{Key: "foo", HandleTimeout: 100 * time.Millisecond}
+	release, err := limiter.Acquire(context.Background(), message)
+	assert.NoError(t, err)
+
+	// the slot is still held after the HandleTimeout, as the handler is running
+	time.Sleep(300 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Acquire(ctx, message)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release, err = limiter.Acquire(context.Background(), message)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestLocalLimiter_rate(t *testing.T) {
+	limiter := &LocalLimiter{Limits: map[string]Limit{"foo": {Rate: 100, Burst: 1}}}
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		release, err := limiter.Acquire(context.Background(), &PersistedEvent{Key: "foo"})
+		assert.NoError(t, err)
+		release()
+	}
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+
+	// unlimited event types are not blocked
+	release, err := limiter.Acquire(context.Background(), &PersistedEvent{Key: "bar"})
+	assert.NoError(t, err)
+	release()
+
+	limiter = &LocalLimiter{Limits: map[string]Limit{"foo": {Rate: 1}}}
+	_, err = limiter.Acquire(context.Background(), &PersistedEvent{Key: "foo"})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limiter.Acquire(ctx, &PersistedEvent{Key: "foo"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// slowLimiter allows every handler after the wait.
+type slowLimiter time.Duration
+
+func (s slowLimiter) Acquire(ctx context.Context, message *PersistedEvent) (func(), error) {
+	select {
+	case <-time.After(time.Duration(s)):
+		return func() {}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDispatcher_limiterWait(t *testing.T) {
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, driver, UseLimiter(slowLimiter(200*time.Millisecond)))
+	handled := make(chan struct{}, 1)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		handled <- struct{}{}
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Consume(ctx)
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}), Timeout(50*time.Millisecond))))
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("the event is not handled")
+	}
+	time.Sleep(100 * time.Millisecond)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{}, info)
+}
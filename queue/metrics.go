@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"github.com/go-kit/kit/metrics"
+)
+
+// Metrics is a collection of metrics for the handling of persisted events. All metrics are labeled by "queue" and
+// "event". Any of them can be left nil if not needed. See observability.ProvideQueueMetrics for a ready-made
+// collection.
+type Metrics struct {
+	// Duration observes the time spent in the handlers, in seconds.
+	Duration metrics.Histogram
+	// Latency observes the time between the dispatch (or the end of delay) and the first attempt to handle the
+	// event, in seconds.
+	Latency metrics.Histogram
+	// Processed counts the events handled successfully.
+	Processed metrics.Counter
+	// Retried counts the failed attempts which are going to be retried.
+	Retried metrics.Counter
+	// Aborted counts the events which failed after the max attempts.
+	Aborted metrics.Counter
+}
+
+// with returns a copy of the Metrics, with labels added to each metric.
+func (m *Metrics) with(labelValues ...string) *Metrics {
+	if m == nil {
+		return nil
+	}
+	var out Metrics
+	if m.Duration != nil {
+		out.Duration = m.Duration.With(labelValues...)
+	}
+	if m.Latency != nil {
+		out.Latency = m.Latency.With(labelValues...)
+	}
+	if m.Processed != nil {
+		out.Processed = m.Processed.With(labelValues...)
+	}
+	if m.Retried != nil {
+		out.Retried = m.Retried.With(labelValues...)
+	}
+	if m.Aborted != nil {
+		out.Aborted = m.Aborted.With(labelValues...)
+	}
+	return &out
+}
+
+// observe records the value in the histogram, if it is set.
+func observe(histogram metrics.Histogram, msg *PersistedEvent, value float64) {
+	if histogram != nil {
+		histogram.With("event", msg.Key).Observe(value)
+	}
+}
+
+// count increments the counter, if it is set.
+func count(counter metrics.Counter, msg *PersistedEvent) {
+	if counter != nil {
+		counter.With("event", msg.Key).Add(1)
+	}
+}
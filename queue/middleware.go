@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// Handler handles a persisted event popped from the queue. If an error is returned, the event is retried or
+// failed, depending on its attempts. Otherwise, the event is acknowledged.
+type Handler func(ctx context.Context, message *PersistedEvent) error
+
+// Middleware wraps the handling of persisted events. It can be used to add cross-cutting behavior around every
+// consumed event, such as logging or restoring values in the context. See UseMiddleware.
+type Middleware func(next Handler) Handler
+
+// Chain composes middlewares into one. The first middleware is the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// PanicError is returned by the handler when a listener or a middleware panics.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+// Error implements error.
+func (p PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// recoverPanic converts panics in the handler to PanicError, so that a panicking listener won't take down the
+// consumer.
+func recoverPanic(next Handler) Handler {
+	return func(ctx context.Context, message *PersistedEvent) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		return next(ctx, message)
+	}
+}
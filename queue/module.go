@@ -1,6 +1,15 @@
 package queue
 
 import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/DoNewsCode/core/srvhttp"
+	"github.com/DoNewsCode/core/unierr"
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -16,10 +25,13 @@ func New(factory *DispatcherFactory) Module {
 }
 
 // ProvideCommand implements CommandProvider for the Module. It registers flush
-// and reload command to the parent command.
+// and reload command to the parent command. If the driver implements Inspector,
+// the list, show, retry and delete commands can be used to inspect and replay
+// individual messages.
 func (m Module) ProvideCommand(command *cobra.Command) {
 	var queueName string
 	var channels []string
+	var offset, limit int64
 	flushCmd := &cobra.Command{
 		Use:   "flush [-q queue] [-c channels]...",
 		Short: "flush the timeout or failed events",
@@ -50,6 +62,112 @@ func (m Module) ProvideCommand(command *cobra.Command) {
 			return nil
 		},
 	}
+	listCmd := &cobra.Command{
+		Use:   "list [-q queue] [-c channels]... [--offset offset] [--limit limit]",
+		Short: "list the events in channels",
+		Long:  "list the events in the timeout, failed or delayed channels, showing their unique id, type and attempts.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspector, _, err := m.inspector(queueName)
+			if err != nil {
+				return errors.Wrap(err, "queue list command")
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "CHANNEL\tUNIQUE ID\tTYPE\tATTEMPTS")
+			for _, ch := range channels {
+				messages, err := inspector.List(cmd.Context(), ch, offset, limit)
+				if err != nil {
+					return errors.Wrap(err, "queue list command")
+				}
+				for _, msg := range messages {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\n", ch, msg.UniqueId, msg.Key, msg.Attempts, msg.MaxAttempts)
+				}
+			}
+			return w.Flush()
+		},
+	}
+	listCmd.Flags().Int64Var(&offset, "offset", 0, "the number of events to skip in each channel")
+	listCmd.Flags().Int64Var(&limit, "limit", 20, "the maximum number of events to list in each channel")
+
+	showCmd := &cobra.Command{
+		Use:   "show [-q queue] [-c channels]... id",
+		Short: "show an event",
+		Long:  "show an event in the timeout or failed channels by its unique id, including the payload.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspector, dispatcher, err := m.inspector(queueName)
+			if err != nil {
+				return errors.Wrap(err, "queue show command")
+			}
+			ch, msg, err := find(cmd.Context(), inspector, channels, args[0])
+			if err != nil {
+				return errors.Wrap(err, "queue show command")
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "Channel:\t%s\n", ch)
+			fmt.Fprintf(w, "UniqueId:\t%s\n", msg.UniqueId)
+			fmt.Fprintf(w, "Type:\t%s\n", msg.Key)
+			fmt.Fprintf(w, "Attempts:\t%d/%d\n", msg.Attempts, msg.MaxAttempts)
+			fmt.Fprintf(w, "HandleTimeout:\t%s\n", msg.HandleTimeout)
+			fmt.Fprintf(w, "Backoff:\t%s\n", msg.Backoff)
+			fmt.Fprintf(w, "BackoffPolicy:\t%s\n", msg.BackoffPolicy)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			// The payload can only be decoded if the event type has been subscribed to.
+			if data, err := dispatcher.decode(msg); err == nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Payload:\n%+v\n", data)
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Payload:\n%s", hex.Dump(msg.Value))
+			return nil
+		},
+	}
+	retryCmd := &cobra.Command{
+		Use:   "retry [-q queue] [-c channels]... id",
+		Short: "retry an event",
+		Long:  "move an event in the timeout or failed channels to the waiting channel by its unique id. Like reload, the number of attempts is not reset.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspector, dispatcher, err := m.inspector(queueName)
+			if err != nil {
+				return errors.Wrap(err, "queue retry command")
+			}
+			ch, msg, err := find(cmd.Context(), inspector, channels, args[0])
+			if err != nil {
+				return errors.Wrap(err, "queue retry command")
+			}
+			// The event is removed only after being pushed, so that it is never lost. A copy is pushed, as drivers
+			// may tell messages apart by their addresses.
+			retried := *msg
+			if err := dispatcher.Driver().Push(cmd.Context(), &retried, 0); err != nil {
+				return errors.Wrap(err, "queue retry command")
+			}
+			if _, err := inspector.Remove(cmd.Context(), ch, args[0]); err != nil {
+				return errors.Wrapf(err, "queue retry command: event %s is pushed, but not removed from %s", args[0], ch)
+			}
+			return nil
+		},
+	}
+	deleteCmd := &cobra.Command{
+		Use:   "delete [-q queue] [-c channels]... id",
+		Short: "delete an event",
+		Long:  "delete an event in the timeout or failed channels by its unique id.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspector, _, err := m.inspector(queueName)
+			if err != nil {
+				return errors.Wrap(err, "queue delete command")
+			}
+			ch, _, err := find(cmd.Context(), inspector, channels, args[0])
+			if err != nil {
+				return errors.Wrap(err, "queue delete command")
+			}
+			if _, err := inspector.Remove(cmd.Context(), ch, args[0]); err != nil {
+				return errors.Wrap(err, "queue delete command")
+			}
+			return nil
+		},
+	}
 	queueCmd := &cobra.Command{
 		Use:   "queue",
 		Short: "manage queues",
@@ -57,6 +175,78 @@ func (m Module) ProvideCommand(command *cobra.Command) {
 	}
 	queueCmd.PersistentFlags().StringVarP(&queueName, "queue", "q", "default", "the queue name")
 	queueCmd.PersistentFlags().StringSliceVarP(&channels, "channels", "c", []string{"timeout", "failed"}, "the queue name")
-	queueCmd.AddCommand(reloadCmd, flushCmd)
+	queueCmd.AddCommand(reloadCmd, flushCmd, listCmd, showCmd, retryCmd, deleteCmd)
 	command.AddCommand(queueCmd)
 }
+
+func (m Module) inspector(queueName string) (Inspector, *QueueableDispatcher, error) {
+	queueDispatcher, err := m.Factory.Make(queueName)
+	if err != nil {
+		return nil, nil, err
+	}
+	inspector, ok := queueDispatcher.Driver().(Inspector)
+	if !ok {
+		return nil, nil, fmt.Errorf("the driver %T of queue %s doesn't support inspecting messages", queueDispatcher.Driver(), queueName)
+	}
+	return inspector, queueDispatcher, nil
+}
+
+// find looks for the message with the given unique id in channels, and returns the channel it belongs to.
+func find(ctx context.Context, inspector Inspector, channels []string, uniqueId string) (string, *PersistedEvent, error) {
+	const pageSize = 100
+	for _, ch := range channels {
+		for offset := int64(0); ; offset += pageSize {
+			messages, err := inspector.List(ctx, ch, offset, pageSize)
+			if err != nil {
+				return "", nil, err
+			}
+			for _, msg := range messages {
+				if msg.UniqueId == uniqueId {
+					return ch, msg, nil
+				}
+			}
+			if len(messages) < pageSize {
+				break
+			}
+		}
+	}
+	return "", nil, ErrNotFound
+}
+
+// StatusModule serves the Status of persisted events over HTTP, at ``/queue/{queue}/jobs/{uniqueId}``. It is
+// useful for frontends polling long-running jobs. The dispatcher of the queue must have a StatusStore. See
+// UseStatusStore.
+//
+//  c.AddModuleFunc(queue.NewStatusModule)
+type StatusModule struct {
+	Maker DispatcherMaker
+}
+
+// NewStatusModule creates a new StatusModule.
+func NewStatusModule(maker DispatcherMaker) StatusModule {
+	return StatusModule{Maker: maker}
+}
+
+// ProvideHTTP implements container.HTTPProvider
+func (s StatusModule) ProvideHTTP(router *mux.Router) {
+	router.Methods(http.MethodGet).Path("/queue/{queue}/jobs/{uniqueId}").HandlerFunc(s.serveStatus)
+}
+
+func (s StatusModule) serveStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	encoder := srvhttp.NewResponseEncoder(w)
+	dispatcher, err := s.Maker.Make(vars["queue"])
+	if err != nil {
+		encoder.EncodeError(unierr.NotFoundErr(err, "queue %s not found", vars["queue"]))
+		return
+	}
+	status, err := dispatcher.Status(r.Context(), vars["uniqueId"])
+	switch {
+	case errors.Is(err, ErrNotFound):
+		encoder.EncodeError(unierr.NotFoundErr(err, "job %s not found", vars["uniqueId"]))
+	case errors.Is(err, ErrNoStatus):
+		encoder.EncodeError(unierr.UnimplementedErr(err, "queue %s doesn't track job status", vars["queue"]))
+	default:
+		encoder.Encode(status, err)
+	}
+}
@@ -1,9 +1,17 @@
 package queue
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
 	"github.com/DoNewsCode/core/di"
 	"github.com/DoNewsCode/core/events"
+	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -62,3 +70,126 @@ func setUpModule() (*cobra.Command, Driver) {
 	mod.ProvideCommand(rootCmd)
 	return rootCmd, driver
 }
+
+func TestModule_inspectCommands(t *testing.T) {
+	ctx := context.Background()
+	rootCmd, driver := setUpModule()
+	for _, id := range []string{"foo", "bar"} {
+		message := &PersistedEvent{UniqueId: id, Key: "event", HandleTimeout: time.Hour, Attempts: 1, MaxAttempts: 1}
+		driver.Push(ctx, message, 0)
+		driver.Pop(ctx)
+		driver.Fail(ctx, message)
+	}
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs([]string{"queue", "list"})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Contains(t, out.String(), "failed   bar")
+	assert.Contains(t, out.String(), "failed   foo")
+
+	out.Reset()
+	rootCmd.SetArgs([]string{"queue", "show", "foo"})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Contains(t, out.String(), "Attempts:       1/1")
+
+	rootCmd.SetArgs([]string{"queue", "retry", "foo"})
+	assert.NoError(t, rootCmd.Execute())
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Waiting: 1, Failed: 1}, info)
+
+	rootCmd.SetArgs([]string{"queue", "delete", "bar"})
+	assert.NoError(t, rootCmd.Execute())
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, QueueInfo{Waiting: 1}, info)
+
+	rootCmd.SetArgs([]string{"queue", "delete", "bar"})
+	assert.ErrorIs(t, rootCmd.Execute(), ErrNotFound)
+}
+
+// failingDriver fails every push.
+type failingDriver struct {
+	*InProcessDriver
+}
+
+func (f failingDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	return errors.New("push failed")
+}
+
+func TestModule_retryCommand(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("duplicate", func(t *testing.T) {
+		rootCmd, driver := setUpModule()
+		failed := &PersistedEvent{UniqueId: "foo", UniquePolicy: DropDuplicate, HandleTimeout: time.Hour}
+		driver.Push(ctx, failed, 0)
+		driver.Pop(ctx)
+		driver.Fail(ctx, failed)
+		driver.Push(ctx, &PersistedEvent{UniqueId: "foo", UniquePolicy: DropDuplicate, HandleTimeout: time.Hour}, 0)
+
+		rootCmd.SetArgs([]string{"queue", "retry", "foo"})
+		assert.ErrorIs(t, rootCmd.Execute(), ErrDuplicate)
+		info, _ := driver.Info(ctx)
+		assert.Equal(t, QueueInfo{Waiting: 1, Failed: 1}, info)
+	})
+
+	t.Run("push failed", func(t *testing.T) {
+		driver := failingDriver{NewInProcessDriverWithPopInterval(time.Millisecond)}
+		factory := di.NewFactory(func(name string) (di.Pair, error) {
+			return di.Pair{Conn: WithQueue(&events.SyncDispatcher{}, driver)}, nil
+		})
+		rootCmd := &cobra.Command{}
+		Module{Factory: &DispatcherFactory{Factory: factory}}.ProvideCommand(rootCmd)
+		failed := &PersistedEvent{UniqueId: "foo", HandleTimeout: time.Hour}
+		driver.InProcessDriver.Push(ctx, failed, 0)
+		driver.Pop(ctx)
+		driver.Fail(ctx, failed)
+
+		rootCmd.SetArgs([]string{"queue", "retry", "foo"})
+		assert.Error(t, rootCmd.Execute())
+		info, _ := driver.Info(ctx)
+		assert.Equal(t, QueueInfo{Failed: 1}, info)
+	})
+}
+
+func TestStatusModule(t *testing.T) {
+	store := setUpGormStatusStore(t)
+	store.Set(context.Background(), Status{UniqueId: "foo", Key: "event", State: StateSucceeded, Attempts: 1, Result: []byte(`{"url":"bar"}`)})
+	factory := di.NewFactory(func(name string) (di.Pair, error) {
+		if name == "untracked" {
+			return di.Pair{Conn: WithQueue(&events.SyncDispatcher{}, NewInProcessDriver())}, nil
+		}
+		if name != "default" {
+			return di.Pair{}, fmt.Errorf("queue configuration %s not found", name)
+		}
+		return di.Pair{Conn: WithQueue(&events.SyncDispatcher{}, NewInProcessDriver(), UseStatusStore(store))}, nil
+	})
+	router := mux.NewRouter()
+	NewStatusModule(DispatcherFactory{Factory: factory}).ProvideHTTP(router)
+
+	cases := []struct {
+		name string
+		path string
+		code int
+	}{
+		{"found", "/queue/default/jobs/foo", http.StatusOK},
+		{"job not found", "/queue/default/jobs/bar", http.StatusNotFound},
+		{"queue not found", "/queue/foo/jobs/foo", http.StatusNotFound},
+		{"untracked", "/queue/untracked/jobs/foo", http.StatusNotImplemented},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+			assert.Equal(t, c.code, w.Code)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/queue/default/jobs/foo", nil))
+	var status Status
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, StateSucceeded, status.State)
+	assert.JSONEq(t, `{"url":"bar"}`, string(status.Result))
+}
@@ -3,9 +3,26 @@ package queue
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
 	"reflect"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// Content types of the payloads produced by the bundled packers. The content type is saved in
+// PersistedEvent.ContentType, so that consumers can pick the matching packer.
+const (
+	GobContentType      = "application/x-gob"
+	JSONContentType     = "application/json"
+	ProtobufContentType = "application/x-protobuf"
 )
 
+// ContentTyper is an optional interface for Packer. It reports the content type of the produced bytes.
+type ContentTyper interface {
+	ContentType() string
+}
+
 type packer struct {
 }
 
@@ -26,3 +43,82 @@ func (p packer) Unmarshal(data []byte, message interface{}) error {
 	}
 	return gob.NewDecoder(buf).Decode(message)
 }
+
+// ContentType implements ContentTyper.
+func (p packer) ContentType() string {
+	return GobContentType
+}
+
+// JSONPacker is a Packer that serializes events to JSON. Unlike the default gob packer, the payloads can be
+// produced and consumed by other languages, and renaming struct fields won't break them as long as the json tags
+// are kept.
+type JSONPacker struct{}
+
+// Marshal serializes the message to JSON.
+func (p JSONPacker) Marshal(message interface{}) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+// Unmarshal reverses the JSON to message. The message can be a pointer or a reflect.Value of pointer.
+func (p JSONPacker) Unmarshal(data []byte, message interface{}) error {
+	if rvalue, ok := message.(reflect.Value); ok {
+		message = rvalue.Interface()
+	}
+	return json.Unmarshal(data, message)
+}
+
+// ContentType implements ContentTyper.
+func (p JSONPacker) ContentType() string {
+	return JSONContentType
+}
+
+// ProtobufPacker is a Packer that serializes events in protocol buffers. The events must be generated
+// proto.Message, or pointers to them.
+type ProtobufPacker struct{}
+
+// Marshal serializes the message to protocol buffers.
+func (p ProtobufPacker) Marshal(message interface{}) ([]byte, error) {
+	if m, ok := message.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	// The message may be a value, while proto.Message is implemented by the pointer.
+	ptr := reflect.New(reflect.TypeOf(message))
+	ptr.Elem().Set(reflect.ValueOf(message))
+	if m, ok := ptr.Interface().(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("%T is not a proto.Message", message)
+}
+
+// Unmarshal reverses the protocol buffers to message. The message can be a pointer or a reflect.Value of pointer.
+func (p ProtobufPacker) Unmarshal(data []byte, message interface{}) error {
+	rvalue, ok := message.(reflect.Value)
+	if !ok {
+		rvalue = reflect.ValueOf(message)
+	}
+	// The event may be a pointer itself, in which case a pointer to pointer is passed.
+	if rvalue.Kind() == reflect.Ptr && rvalue.Elem().Kind() == reflect.Ptr {
+		if rvalue.Elem().IsNil() {
+			rvalue.Elem().Set(reflect.New(rvalue.Elem().Type().Elem()))
+		}
+		rvalue = rvalue.Elem()
+	}
+	m, ok := rvalue.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("%s is not a proto.Message", rvalue.Type())
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// ContentType implements ContentTyper.
+func (p ProtobufPacker) ContentType() string {
+	return ProtobufContentType
+}
+
+// contentTypeOf returns the content type produced by the packer, or an empty string if unknown.
+func contentTypeOf(p Packer) string {
+	if typer, ok := p.(ContentTyper); ok {
+		return typer.ContentType()
+	}
+	return ""
+}
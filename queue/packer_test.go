@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/gogo/protobuf/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackers(t *testing.T) {
+	cases := []struct {
+		name   string
+		packer Packer
+		data   interface{}
+	}{
+		{"gob", packer{}, MockEvent{Value: "foo"}},
+		{"json", JSONPacker{}, MockEvent{Value: "foo"}},
+		{"protobuf", ProtobufPacker{}, types.StringValue{Value: "foo"}},
+		{"protobuf pointer", ProtobufPacker{}, &types.StringValue{Value: "foo"}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			data, err := c.packer.Marshal(c.data)
+			assert.NoError(t, err)
+			ptr := reflect.New(reflect.TypeOf(c.data))
+			assert.NoError(t, c.packer.Unmarshal(data, ptr))
+			assert.Equal(t, c.data, ptr.Elem().Interface())
+		})
+	}
+}
+
+func TestEnvelopePacker(t *testing.T) {
+	msg := &PersistedEvent{
+		UniqueId:      "foo",
+		Key:           "bar",
+		Value:         []byte(`{"Value":"baz"}`),
+		ContentType:   JSONContentType,
+		SchemaVersion: 2,
+		HandleTimeout: time.Minute,
+		Attempts:      1,
+		MaxAttempts:   3,
+		DueAt:         time.Unix(1600000000, 0),
+		SpanContext:   []string{"trace", "id"},
+	}
+	data, err := EnvelopePacker{}.Marshal(msg)
+	assert.NoError(t, err)
+
+	var envelope map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &envelope))
+	assert.Equal(t, float64(EnvelopeVersion), envelope["version"])
+	assert.Equal(t, map[string]interface{}{"Value": "baz"}, envelope["payload"])
+
+	var decoded PersistedEvent
+	assert.NoError(t, EnvelopePacker{}.Unmarshal(data, &decoded))
+	assert.True(t, msg.DueAt.Equal(decoded.DueAt))
+	decoded.DueAt = msg.DueAt
+	assert.Equal(t, *msg, decoded)
+
+	// The encoding must be stable, as some drivers look up messages by their bytes.
+	again, _ := EnvelopePacker{}.Marshal(&decoded)
+	assert.Equal(t, data, again)
+
+	// Messages packed by gob are still readable.
+	legacy, _ := packer{}.Marshal(msg)
+	decoded = PersistedEvent{}
+	assert.NoError(t, EnvelopePacker{}.Unmarshal(legacy, &decoded))
+	assert.Equal(t, "foo", decoded.UniqueId)
+
+	assert.Error(t, EnvelopePacker{}.Unmarshal([]byte(`{"version": 100}`), &decoded))
+}
+
+func TestDispatcher_upcaster(t *testing.T) {
+	driver := NewInProcessDriverWithPopInterval(time.Millisecond)
+	dispatcher := WithQueue(
+		&events.SyncDispatcher{},
+		driver,
+		UseParallelism(1),
+		UsePacker(JSONPacker{}),
+		UseUpcaster(events.Of(MockEvent{}).Type(), func(message *PersistedEvent) error {
+			if message.SchemaVersion < 2 {
+				message.Value = []byte(`{"Value":"upcasted"}`)
+				message.SchemaVersion = 2
+			}
+			return nil
+		}),
+	)
+	received := make(chan string)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		received <- event.Data().(MockEvent).Value
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Consume(ctx)
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "v1"}))))
+	assert.Equal(t, "upcasted", <-received)
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "v2"}), SchemaVersion(2))))
+	assert.Equal(t, "v2", <-received)
+
+	// Payloads from a producer with another bundled packer can be decoded as well.
+	gob, _ := packer{}.Marshal(MockEvent{Value: "gob"})
+	assert.NoError(t, driver.Push(ctx, &PersistedEvent{
+		Key:           events.Of(MockEvent{}).Type(),
+		Value:         gob,
+		ContentType:   GobContentType,
+		SchemaVersion: 2,
+		HandleTimeout: time.Second,
+		Attempts:      1,
+		MaxAttempts:   1,
+	}, 0))
+	assert.Equal(t, "gob", <-received)
+}
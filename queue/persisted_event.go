@@ -11,17 +11,40 @@ type PersistedEvent struct {
 	Key string
 	// Value is the serialized bytes of the event.
 	Value []byte
+	// ContentType is the format of the Value, such as JSONContentType. If empty, the Value is decoded by the
+	// Packer of the dispatcher.
+	ContentType string
+	// SchemaVersion is the version of the event struct when the Value is serialized. See UseUpcaster.
+	SchemaVersion int
 	// HandleTimeout sets the upper time limit for each run of the handler. If handleTimeout exceeds, the event will
 	// be put onto the timeout queue. Note: the timeout is shared among all listeners.
 	HandleTimeout time.Duration
-	// Backoff sets the duration before next retry.
+	// Backoff sets the duration before next retry. How it is interpreted depends on the BackoffPolicy.
 	Backoff time.Duration
+	// BackoffPolicy is the name of the BackoffPolicy used to compute the duration before next retry. If empty,
+	// the Backoff is doubled on each retry.
+	BackoffPolicy string
+	// UniquePolicy decides what happens when a message with the same UniqueId is already waiting, delayed or
+	// reserved. It is either DropDuplicate or ReplaceDuplicate. If empty, messages are not deduplicated.
+	UniquePolicy string
+	// Priority is the waiting lane of the message. Messages in higher lanes are consumed first. By default,
+	// Priority is 0, the lowest lane.
+	Priority int
 	// Attempts denotes how many retry has been attempted. It starts from 1.
 	Attempts int
 	// MaxAttempts denotes the maximum number of time the handler can retry before the event is put onto
 	// the failed queue.
 	// By default, MaxAttempts is 1.
 	MaxAttempts int
+	// DueAt is when the message is supposed to be handled, ie. the time of dispatch plus the delay. It is used to
+	// measure how long the message waits in the queue.
+	DueAt time.Time
+	// SpanContext is the opentracing span context of the producer, serialized as a list of keys and values. The
+	// consumer span becomes a child of it.
+	SpanContext []string
+	// CorrelationID is the dtx.CorrelationID found in the context of the producer. It is restored in the context
+	// passed to listeners.
+	CorrelationID string
 }
 
 // Type implements contract.event. It returns the Key.
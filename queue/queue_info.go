@@ -2,8 +2,11 @@ package queue
 
 // QueueInfo describes the state of queues.
 type QueueInfo struct {
-	// Waiting is the length of the Waiting queue.
+	// Waiting is the length of the Waiting queue. If there are several lanes, it is the sum of all lanes.
 	Waiting int64
+	// Lanes is the length of each waiting lane, starting from the lowest priority. It is only set if the queue
+	// has more than one lane.
+	Lanes []int64
 	// Delayed is the length of the Delayed queue.
 	Delayed int64
 	//Timeout is the length of the Timeout queue.
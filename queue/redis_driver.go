@@ -22,14 +22,19 @@ type Packer interface {
 }
 
 // RedisDriver is a queue driver backed by redis. It is easy to setup, and offers at least once semantic.
+// Channels can be referred either by their redis keys in ChannelConfig, or by their plain names such as "failed".
 type RedisDriver struct {
 	Logger        log.Logger            // Logger is an optional logger. By default a noop logger is used
 	RedisClient   redis.UniversalClient // RedisClient is used to communicate with redis
 	ChannelConfig ChannelConfig         // ChannelConfig holds the name of redis keys for all queues.
 	PopTimeout    time.Duration         // PopTimeout is the BRPOP timeout. ie. How long the pop action will block at most.
 	Packer        Packer                // Packer describes how to save the message in wire format
-	lock          sync.Mutex
-	defaultLoaded bool
+	// StarvationGuard only matters when there are several lanes. Once in every StarvationGuard pops, a lower lane
+	// is served first, so that it can't be starved by higher ones. Defaults to 10. Negative value disables it.
+	StarvationGuard int
+	lock            sync.Mutex
+	defaultLoaded   bool
+	pops            uint64
 }
 
 // Push pushes the message onto the queue. It is possible to specify a time delay. If so the message
@@ -40,8 +45,11 @@ func (r *RedisDriver) Push(ctx context.Context, message *PersistedEvent, delay t
 	if err != nil {
 		return errors.Wrap(err, "failed to compress message")
 	}
+	if message.UniquePolicy != "" {
+		return r.pushUnique(ctx, message, data, delay)
+	}
 	if delay <= time.Duration(0) {
-		_, err = r.RedisClient.LPush(ctx, r.ChannelConfig.Waiting, data).Result()
+		_, err = r.RedisClient.LPush(ctx, r.ChannelConfig.Lane(message.Priority), data).Result()
 		if err != nil {
 			return errors.Wrap(err, "failed to lpush while pushing")
 		}
@@ -68,7 +76,15 @@ func (r *RedisDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
 		return nil, err
 	}
 
-	res, err := r.RedisClient.BRPop(ctx, r.PopTimeout, r.ChannelConfig.Waiting).Result()
+	r.lock.Lock()
+	r.pops++
+	order := laneOrder(r.ChannelConfig.Lanes, r.pops, r.StarvationGuard)
+	r.lock.Unlock()
+	keys := make([]string, len(order))
+	for i, n := range order {
+		keys[i] = r.ChannelConfig.Lane(n)
+	}
+	res, err := r.RedisClient.BRPop(ctx, r.PopTimeout, keys...).Result()
 	if err == redis.Nil {
 		return nil, ErrEmpty
 	}
@@ -81,11 +97,21 @@ func (r *RedisDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to decompress message")
 	}
-	_, err = r.RedisClient.ZAdd(ctx, r.ChannelConfig.Reserved, &redis.Z{
+	// Messages from other producers may be encoded differently. The reserved one must be in the form Ack expects,
+	// and so must the unique index refer to it, or the UniqueId would never be released.
+	raw := data
+	if canonical, err := r.Packer.Marshal(&message); err == nil {
+		data = string(canonical)
+	}
+	p := r.RedisClient.TxPipeline()
+	p.ZAdd(ctx, r.ChannelConfig.Reserved, &redis.Z{
 		Score:  float64(time.Now().Add(message.HandleTimeout).Unix()),
 		Member: data,
-	}).Result()
-	if err != nil {
+	})
+	if message.UniquePolicy != "" && data != raw {
+		r.swapUnique(ctx, p, message.UniqueId, []byte(raw), []byte(data))
+	}
+	if _, err = p.Exec(ctx); err != nil {
 		return nil, errors.Wrap(err, "failed to zadd while putting message on the reserved queue")
 	}
 	return &message, nil
@@ -99,7 +125,16 @@ func (r *RedisDriver) Ack(ctx context.Context, message *PersistedEvent) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to compress message")
 	}
-	return r.remove(ctx, r.ChannelConfig.Reserved, data)
+	if message.UniquePolicy == "" {
+		return r.remove(ctx, r.ChannelConfig.Reserved, data)
+	}
+	p := r.RedisClient.TxPipeline()
+	p.ZRem(ctx, r.ChannelConfig.Reserved, data)
+	r.swapUnique(ctx, p, message.UniqueId, data, nil)
+	if _, err := p.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to zrem while acknowledging message")
+	}
+	return nil
 }
 
 // Fail marks a message has failed.
@@ -111,6 +146,9 @@ func (r *RedisDriver) Fail(ctx context.Context, message *PersistedEvent) error {
 		return errors.Wrap(err, "failed to compress message")
 	}
 	p.ZRem(ctx, r.ChannelConfig.Reserved, data)
+	if message.UniquePolicy != "" {
+		r.swapUnique(ctx, p, message.UniqueId, data, nil)
+	}
 	message.Attempts++
 	data, err = r.Packer.Marshal(message)
 	if err != nil {
@@ -124,12 +162,47 @@ func (r *RedisDriver) Fail(ctx context.Context, message *PersistedEvent) error {
 	return nil
 }
 
+// Release puts a reserved message back onto the waiting queue right away, without counting an attempt. Messages no
+// longer reserved, for example moved to the timeout queue already, are left alone. It implements Releaser.
+func (r *RedisDriver) Release(ctx context.Context, message *PersistedEvent) error {
+	r.populateDefaults()
+	data, err := r.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	keys := []string{r.ChannelConfig.Reserved, r.ChannelConfig.Lane(message.Priority)}
+	if err := releaseScript.Run(ctx, r.RedisClient, keys, data).Err(); err != nil {
+		return errors.Wrap(err, "failed to release message")
+	}
+	return nil
+}
+
+// Extend postpones the timeout of a reserved message. It implements Extender.
+func (r *RedisDriver) Extend(ctx context.Context, message *PersistedEvent, timeout time.Duration) error {
+	r.populateDefaults()
+	data, err := r.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	due := time.Now().Add(timeout).Unix()
+	extended, err := extendScript.Run(ctx, r.RedisClient, []string{r.ChannelConfig.Reserved}, data, due).Int()
+	if err != nil {
+		return errors.Wrap(err, "failed to extend reservation")
+	}
+	if extended == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // Reload put failed/timeout message back to the Waiting queue. If the temporary outage have been cleared,
 // messages can be tried again via Reload. Reload is not a normal retry.
 // It similarly gives otherwise dead messages one more chance,
 // but this chance is not subject to the limit of MaxAttempts, nor does it reset the number of time attempted.
+// Reloaded messages are put onto the lowest lane regardless of their priority.
 func (r *RedisDriver) Reload(ctx context.Context, channel string) (int64, error) {
 	r.populateDefaults()
+	channel = r.channel(channel)
 	if channel != r.ChannelConfig.Failed && channel != r.ChannelConfig.Timeout {
 		return 0, fmt.Errorf("reloading %s is not allowed", channel)
 	}
@@ -150,7 +223,18 @@ func (r *RedisDriver) Reload(ctx context.Context, channel string) (int64, error)
 // Flush flushes a queue of choice by deleting all its data. Use with caution.
 func (r *RedisDriver) Flush(ctx context.Context, channel string) error {
 	r.populateDefaults()
-	_, err := r.RedisClient.Del(ctx, channel).Result()
+	channel = r.channel(channel)
+	keys := []string{channel}
+	if channel == r.ChannelConfig.Waiting {
+		for n := 1; n < r.ChannelConfig.Lanes; n++ {
+			keys = append(keys, r.ChannelConfig.Lane(n))
+		}
+	}
+	if channel == r.ChannelConfig.Waiting || channel == r.ChannelConfig.Delayed || channel == r.ChannelConfig.Reserved {
+		// The index may refer to the flushed messages. Messages in other channels lose their uniqueness as well.
+		keys = append(keys, r.ChannelConfig.Unique)
+	}
+	_, err := r.RedisClient.Del(ctx, keys...).Result()
 	if err != nil {
 		return errors.Wrapf(err, "failed to flush %s", channel)
 	}
@@ -175,7 +259,15 @@ func (r *RedisDriver) Info(ctx context.Context) (QueueInfo, error) {
 		oneByOne attempt
 		info     QueueInfo
 	)
-	oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Waiting), &info.Waiting)
+	if r.ChannelConfig.Lanes > 1 {
+		info.Lanes = make([]int64, r.ChannelConfig.Lanes)
+		for n := range info.Lanes {
+			oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Lane(n)), &info.Lanes[n])
+			info.Waiting += info.Lanes[n]
+		}
+	} else {
+		oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Waiting), &info.Waiting)
+	}
 	oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Failed), &info.Failed)
 	oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Timeout), &info.Timeout)
 	oneByOne.try(r.RedisClient.ZCard(ctx, r.ChannelConfig.Delayed), &info.Delayed)
@@ -186,6 +278,142 @@ func (r *RedisDriver) Info(ctx context.Context) (QueueInfo, error) {
 	return info, nil
 }
 
+// List returns at most limit messages from the channel, skipping the first offset ones. If there are several lanes,
+// the waiting channel lists them one after another, starting from the lowest priority. It implements Inspector.
+func (r *RedisDriver) List(ctx context.Context, channel string, offset, limit int64) ([]*PersistedEvent, error) {
+	r.populateDefaults()
+	channel = r.channel(channel)
+	var data []string
+	for _, key := range r.keysOf(channel) {
+		page, err := r.rangeOf(ctx, key, offset, offset+limit-int64(len(data))-1)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, page...)
+		if int64(len(data)) >= limit {
+			break
+		}
+		if len(page) > 0 {
+			offset = 0
+			continue
+		}
+		// The offset is past the end of this lane.
+		length, err := r.RedisClient.LLen(ctx, key).Result()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", key)
+		}
+		if offset -= length; offset < 0 {
+			offset = 0
+		}
+	}
+	messages := make([]*PersistedEvent, 0, len(data))
+	for _, d := range data {
+		var message PersistedEvent
+		if err := r.Packer.Unmarshal([]byte(d), &message); err != nil {
+			return nil, errors.Wrap(err, "failed to decompress message")
+		}
+		messages = append(messages, &message)
+	}
+	return messages, nil
+}
+
+// Remove deletes the message with the given UniqueId from the channel and returns it. The channel is scanned from
+// the beginning, so it can be slow on long channels. If there are several lanes, the waiting channel includes all
+// of them. It implements Inspector.
+func (r *RedisDriver) Remove(ctx context.Context, channel string, uniqueId string) (*PersistedEvent, error) {
+	r.populateDefaults()
+	channel = r.channel(channel)
+	if channel == r.ChannelConfig.Reserved {
+		return nil, fmt.Errorf("removing messages from %s is not allowed", channel)
+	}
+	const pageSize = 100
+	for _, key := range r.keysOf(channel) {
+		for start := int64(0); ; start += pageSize {
+			data, err := r.rangeOf(ctx, key, start, start+pageSize-1)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range data {
+				var message PersistedEvent
+				if err := r.Packer.Unmarshal([]byte(d), &message); err != nil {
+					return nil, errors.Wrap(err, "failed to decompress message")
+				}
+				if message.UniqueId != uniqueId {
+					continue
+				}
+				p := r.RedisClient.TxPipeline()
+				var removed *redis.IntCmd
+				if channel == r.ChannelConfig.Delayed {
+					removed = p.ZRem(ctx, key, d)
+				} else {
+					removed = p.LRem(ctx, key, 1, d)
+				}
+				// Messages in the failed and timeout channels are no longer indexed.
+				if message.UniquePolicy != "" && channel != r.ChannelConfig.Failed && channel != r.ChannelConfig.Timeout {
+					r.swapUnique(ctx, p, message.UniqueId, []byte(d), nil)
+				}
+				if _, err := p.Exec(ctx); err != nil {
+					return nil, errors.Wrapf(err, "failed to remove message from %s", key)
+				}
+				if removed.Val() == 0 {
+					return nil, ErrNotFound
+				}
+				return &message, nil
+			}
+			if len(data) < pageSize {
+				break
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// keysOf returns the redis keys of the channel. The waiting channel has one key per lane.
+func (r *RedisDriver) keysOf(channel string) []string {
+	if channel != r.ChannelConfig.Waiting || r.ChannelConfig.Lanes <= 1 {
+		return []string{channel}
+	}
+	keys := make([]string, r.ChannelConfig.Lanes)
+	for n := range keys {
+		keys[n] = r.ChannelConfig.Lane(n)
+	}
+	return keys
+}
+
+// rangeOf returns the raw messages between start and stop (inclusive) in the channel.
+func (r *RedisDriver) rangeOf(ctx context.Context, channel string, start, stop int64) ([]string, error) {
+	var (
+		data []string
+		err  error
+	)
+	if channel == r.ChannelConfig.Delayed || channel == r.ChannelConfig.Reserved {
+		data, err = r.RedisClient.ZRange(ctx, channel, start, stop).Result()
+	} else {
+		data, err = r.RedisClient.LRange(ctx, channel, start, stop).Result()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", channel)
+	}
+	return data, nil
+}
+
+// channel resolves plain channel names to redis keys. Other names are returned as is.
+func (r *RedisDriver) channel(name string) string {
+	switch name {
+	case channelWaiting:
+		return r.ChannelConfig.Waiting
+	case channelDelayed:
+		return r.ChannelConfig.Delayed
+	case channelReserved:
+		return r.ChannelConfig.Reserved
+	case channelTimeout:
+		return r.ChannelConfig.Timeout
+	case channelFailed:
+		return r.ChannelConfig.Failed
+	}
+	return name
+}
+
 func (r *RedisDriver) remove(ctx context.Context, channel string, data []byte) error {
 	_, err := r.RedisClient.ZRem(ctx, channel, string(data)).Result()
 	if err != nil {
@@ -205,13 +433,16 @@ func (r *RedisDriver) Retry(ctx context.Context, message *PersistedEvent) error
 		return errors.Wrap(err, "failed to compress message")
 	}
 	p.ZRem(ctx, r.ChannelConfig.Reserved, string(data))
-	message.Backoff = getRetryDuration(message.Backoff)
+	delay := time.Now().Add(nextBackoff(message))
 	message.Attempts++
-	delay := time.Now().Add(message.Backoff)
+	oldData := data
 	data, err = r.Packer.Marshal(message)
 	if err != nil {
 		return errors.Wrap(err, "failed to compress message")
 	}
+	if message.UniquePolicy != "" {
+		r.swapUnique(ctx, p, message.UniqueId, oldData, data)
+	}
 	p.ZAdd(ctx, r.ChannelConfig.Delayed, &redis.Z{
 		Score:  float64(delay.Unix()),
 		Member: data,
@@ -223,6 +454,110 @@ func (r *RedisDriver) Retry(ctx context.Context, message *PersistedEvent) error
 	return nil
 }
 
+// releaseScript moves a message from the reserved queue to the consuming end of a waiting lane.
+// KEYS: reserved, waiting lane. ARGV: message.
+var releaseScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// extendScript updates the due time of a reserved message, if it is still reserved.
+// KEYS: reserved. ARGV: message, due time.
+var extendScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// pushUniqueScript pushes a message unless another one with the same UniqueId is indexed.
+// KEYS: unique index, delayed, the target lane, followed by all lanes.
+// ARGV: UniqueId, message, policy, due time (0 if not delayed).
+var pushUniqueScript = redis.NewScript(`
+local existing = redis.call('HGET', KEYS[1], ARGV[1])
+if existing then
+	if ARGV[3] == 'drop' then
+		return 0
+	end
+	if redis.call('ZREM', KEYS[2], existing) == 0 then
+		for i = 4, #KEYS do
+			if redis.call('LREM', KEYS[i], 1, existing) > 0 then
+				break
+			end
+		end
+	end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if ARGV[4] == '0' then
+	redis.call('LPUSH', KEYS[3], ARGV[2])
+else
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[2])
+end
+return 1
+`)
+
+// swapUniqueScript replaces the indexed message of a UniqueId, only if it still refers to the expected one.
+// The entry is deleted if the replacement is empty. KEYS: unique index. ARGV: UniqueId, expected, replacement.
+const swapUniqueScript = `
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	if ARGV[3] == '' then
+		redis.call('HDEL', KEYS[1], ARGV[1])
+	else
+		redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+	end
+end
+return 0
+`
+
+func (r *RedisDriver) pushUnique(ctx context.Context, message *PersistedEvent, data []byte, delay time.Duration) error {
+	var due int64
+	if delay > time.Duration(0) {
+		due = time.Now().Add(delay).Unix()
+	}
+	keys := []string{r.ChannelConfig.Unique, r.ChannelConfig.Delayed, r.ChannelConfig.Lane(message.Priority)}
+	for n := 0; n < r.ChannelConfig.Lanes || n == 0; n++ {
+		keys = append(keys, r.ChannelConfig.Lane(n))
+	}
+	pushed, err := pushUniqueScript.Run(ctx, r.RedisClient, keys, message.UniqueId, data, message.UniquePolicy, due).Int()
+	if err != nil {
+		return errors.Wrap(err, "failed to push unique message")
+	}
+	if pushed == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+// swapUnique queues the index update of a unique message in the pipeline. Pass nil as the replacement to release
+// the UniqueId.
+func (r *RedisDriver) swapUnique(ctx context.Context, p redis.Pipeliner, uniqueId string, expected, replacement []byte) {
+	p.Eval(ctx, swapUniqueScript, []string{r.ChannelConfig.Unique}, uniqueId, expected, replacement)
+}
+
+// releaseTimeout queues the release of the UniqueId in the pipeline, if the timed out job is a unique message.
+func (r *RedisDriver) releaseTimeout(ctx context.Context, p redis.Pipeliner, job string) {
+	var message PersistedEvent
+	if err := r.Packer.Unmarshal([]byte(job), &message); err != nil || message.UniquePolicy == "" {
+		return
+	}
+	r.swapUnique(ctx, p, message.UniqueId, []byte(job), nil)
+}
+
+// laneOf returns the waiting lane of the job.
+func (r *RedisDriver) laneOf(job string) string {
+	if r.ChannelConfig.Lanes <= 1 {
+		return r.ChannelConfig.Waiting
+	}
+	var message PersistedEvent
+	if err := r.Packer.Unmarshal([]byte(job), &message); err != nil {
+		return r.ChannelConfig.Waiting
+	}
+	return r.ChannelConfig.Lane(message.Priority)
+}
+
 func (r *RedisDriver) move(ctx context.Context, fromKey string, toKey string) error {
 	jobs, _ := r.RedisClient.ZRevRangeByScore(ctx, fromKey, &redis.ZRangeBy{
 		Min:    "-INF",
@@ -233,7 +568,14 @@ func (r *RedisDriver) move(ctx context.Context, fromKey string, toKey string) er
 	p := r.RedisClient.TxPipeline()
 	for _, job := range jobs {
 		p.ZRem(ctx, fromKey, job)
-		p.LPush(ctx, toKey, job)
+		if fromKey == r.ChannelConfig.Delayed {
+			p.LPush(ctx, r.laneOf(job), job)
+		} else {
+			p.LPush(ctx, toKey, job)
+		}
+		if fromKey == r.ChannelConfig.Reserved {
+			r.releaseTimeout(ctx, p, job)
+		}
 	}
 	_, err := p.Exec(ctx)
 	if err != nil {
@@ -272,11 +614,18 @@ func (r *RedisDriver) populateDefaults() {
 			Reserved: "{RedisDriver}:reserved",
 			Waiting:  "{RedisDriver}:waiting",
 			Timeout:  "{RedisDriver}:timeout",
+			Unique:   "{RedisDriver}:unique",
 		}
 	}
+	if r.ChannelConfig.Unique == "" {
+		r.ChannelConfig.Unique = r.ChannelConfig.Waiting + ":unique"
+	}
 	if r.PopTimeout == time.Duration(0) {
 		r.PopTimeout = time.Second
 	}
+	if r.StarvationGuard == 0 {
+		r.StarvationGuard = defaultStarvationGuard
+	}
 	r.defaultLoaded = true
 }
 
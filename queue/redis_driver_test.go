@@ -2,11 +2,15 @@ package queue_test
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/DoNewsCode/core/contract"
 	"github.com/DoNewsCode/core/events"
 	"github.com/DoNewsCode/core/queue"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func setUpInProcessQueueBenchmark(wg *sync.WaitGroup) (*queue.QueueableDispatcher, func()) {
@@ -56,3 +60,155 @@ func BenchmarkInProcessQueue(b *testing.B) {
 	wg.Wait()
 	cancel()
 }
+
+func TestRedisDriver_Inspector(t *testing.T) {
+	ctx := context.Background()
+	driver := &queue.RedisDriver{}
+	driver.Flush(ctx, "failed")
+	driver.Flush(ctx, "delayed")
+	defer driver.Flush(ctx, "failed")
+	defer driver.Flush(ctx, "delayed")
+
+	driver.Push(ctx, &queue.PersistedEvent{UniqueId: "foo"}, time.Hour)
+	driver.Push(ctx, &queue.PersistedEvent{UniqueId: "bar"}, 2*time.Hour)
+
+	messages, err := driver.List(ctx, "delayed", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "foo", messages[0].UniqueId)
+
+	messages, err = driver.List(ctx, "delayed", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "bar", messages[0].UniqueId)
+
+	message, err := driver.Remove(ctx, "delayed", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", message.UniqueId)
+
+	_, err = driver.Remove(ctx, "delayed", "foo")
+	assert.ErrorIs(t, err, queue.ErrNotFound)
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, int64(1), info.Delayed)
+}
+
+func TestRedisDriver_unique(t *testing.T) {
+	ctx := context.Background()
+	driver := &queue.RedisDriver{}
+	for _, ch := range []string{"waiting", "delayed", "reserved"} {
+		driver.Flush(ctx, ch)
+		defer driver.Flush(ctx, ch)
+	}
+
+	first := &queue.PersistedEvent{UniqueId: "foo", Key: "first", UniquePolicy: queue.ReplaceDuplicate, HandleTimeout: time.Hour}
+	second := &queue.PersistedEvent{UniqueId: "foo", Key: "second", UniquePolicy: queue.ReplaceDuplicate, HandleTimeout: time.Hour}
+	third := &queue.PersistedEvent{UniqueId: "foo", Key: "third", UniquePolicy: queue.DropDuplicate, HandleTimeout: time.Hour}
+	assert.NoError(t, driver.Push(ctx, first, time.Hour))
+	assert.NoError(t, driver.Push(ctx, second, 0))
+	assert.ErrorIs(t, driver.Push(ctx, third, 0), queue.ErrDuplicate)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{Waiting: 1}, info)
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", msg.Key)
+
+	assert.ErrorIs(t, driver.Push(ctx, third, 0), queue.ErrDuplicate)
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, int64(0), info.Waiting)
+
+	assert.NoError(t, driver.Ack(ctx, msg))
+	assert.NoError(t, driver.Push(ctx, third, 0))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, int64(1), info.Waiting)
+}
+
+// indentPacker encodes messages differently from JSONPacker, but in a way JSONPacker can decode.
+type indentPacker struct {
+	queue.JSONPacker
+}
+
+func (i indentPacker) Marshal(message interface{}) ([]byte, error) {
+	return json.MarshalIndent(message, "", "  ")
+}
+
+func TestRedisDriver_uniqueOfOtherEncoding(t *testing.T) {
+	ctx := context.Background()
+	producer := &queue.RedisDriver{Packer: indentPacker{}}
+	consumer := &queue.RedisDriver{Packer: queue.JSONPacker{}}
+	for _, ch := range []string{"waiting", "delayed", "reserved"} {
+		consumer.Flush(ctx, ch)
+		defer consumer.Flush(ctx, ch)
+	}
+
+	message := &queue.PersistedEvent{UniqueId: "foo", UniquePolicy: queue.DropDuplicate, HandleTimeout: time.Hour}
+	assert.NoError(t, producer.Push(ctx, message, 0))
+	msg, err := consumer.Pop(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.Ack(ctx, msg))
+
+	assert.NoError(t, producer.Push(ctx, message, 0))
+	info, _ := consumer.Info(ctx)
+	assert.Equal(t, int64(1), info.Waiting)
+}
+
+func TestRedisDriver_priority(t *testing.T) {
+	ctx := context.Background()
+	driver := &queue.RedisDriver{
+		ChannelConfig: queue.ChannelConfig{
+			Delayed:  "{RedisDriver}:delayed",
+			Failed:   "{RedisDriver}:failed",
+			Reserved: "{RedisDriver}:reserved",
+			Waiting:  "{RedisDriver}:waiting",
+			Timeout:  "{RedisDriver}:timeout",
+			Lanes:    2,
+		},
+	}
+	driver.Flush(ctx, "waiting")
+	defer driver.Flush(ctx, "waiting")
+	defer driver.Flush(ctx, "reserved")
+
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{Key: "low"}, 0))
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{Key: "high", Priority: 1}, 0))
+
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{Waiting: 2, Lanes: []int64{1, 1}}, info)
+
+	msg, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "high", msg.Key)
+}
+
+func TestRedisDriver_priorityInspector(t *testing.T) {
+	ctx := context.Background()
+	driver := &queue.RedisDriver{
+		ChannelConfig: queue.ChannelConfig{
+			Delayed:  "{RedisDriver}:delayed",
+			Failed:   "{RedisDriver}:failed",
+			Reserved: "{RedisDriver}:reserved",
+			Waiting:  "{RedisDriver}:waiting",
+			Timeout:  "{RedisDriver}:timeout",
+			Lanes:    3,
+		},
+	}
+	driver.Flush(ctx, "waiting")
+	defer driver.Flush(ctx, "waiting")
+
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{UniqueId: "low"}, 0))
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{UniqueId: "high", Priority: 2}, 0))
+
+	messages, err := driver.List(ctx, "waiting", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	messages, err = driver.List(ctx, "waiting", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "high", messages[0].UniqueId)
+
+	message, err := driver.Remove(ctx, "waiting", "high")
+	assert.NoError(t, err)
+	assert.Equal(t, "high", message.UniqueId)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{Waiting: 1, Lanes: []int64{1, 0, 0}}, info)
+}
@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// rateScript takes a token from the bucket. It returns 0 if a token is taken, or else the milliseconds to wait
+// until the next token is available. KEYS: bucket. ARGV: rate, burst, now in milliseconds.
+var rateScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens')) or burst
+local last = tonumber(redis.call('HGET', KEYS[1], 'last')) or now
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+	last = now
+end
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(last))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return wait
+`)
+
+// slotScript takes a concurrency slot, after evicting the expired ones. It returns 1 on success.
+// KEYS: slots. ARGV: concurrency, now in milliseconds, expiry in milliseconds, slot id.
+var slotScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+	redis.call('PEXPIREAT', KEYS[1], redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')[2])
+	return 1
+end
+return 0
+`)
+
+// renewSlotScript postpones the expiry of a concurrency slot, if it is still held. It returns 1 on success.
+// KEYS: slots. ARGV: expiry in milliseconds, slot id.
+var renewSlotScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	redis.call('PEXPIREAT', KEYS[1], redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')[2])
+	return 1
+end
+return 0
+`)
+
+// RedisLimiter is a Limiter that enforces Limits across all consumers sharing the same redis. Concurrency slots
+// expire after the HandleTimeout of the message, so that crashed consumers won't leak them. While the handler is
+// running, the slot is renewed every half of the HandleTimeout.
+type RedisLimiter struct {
+	RedisClient  redis.UniversalClient // RedisClient is used to communicate with redis
+	Prefix       string                // Prefix is prepended to the redis keys. Usually it is unique to each queue.
+	Limits       map[string]Limit      // Limits is keyed by the event type.
+	PollInterval time.Duration         // PollInterval is how often to try again when all concurrency slots are taken.
+
+	lock          sync.Mutex
+	defaultLoaded bool
+}
+
+// Acquire implements Limiter.
+func (r *RedisLimiter) Acquire(ctx context.Context, message *PersistedEvent) (func(), error) {
+	r.populateDefaults()
+	limit, ok := r.Limits[message.Type()]
+	if !ok {
+		return func() {}, nil
+	}
+	release := func() {}
+	if limit.Concurrency > 0 {
+		var err error
+		if release, err = r.takeSlot(ctx, message, limit); err != nil {
+			return nil, err
+		}
+	}
+	if limit.Rate > 0 {
+		if err := r.takeToken(ctx, message, limit); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func (r *RedisLimiter) takeSlot(ctx context.Context, message *PersistedEvent, limit Limit) (func(), error) {
+	key := fmt.Sprintf("%s:%s:concurrency", r.Prefix, message.Type())
+	id := randomId()
+	for {
+		now := time.Now()
+		expiry := now.Add(message.HandleTimeout)
+		ok, err := slotScript.Run(ctx, r.RedisClient, []string{key}, limit.Concurrency, milliseconds(now), milliseconds(expiry), id).Int()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to take concurrency slot")
+		}
+		if ok == 1 {
+			done := make(chan struct{})
+			go r.renewSlot(done, key, id, message.HandleTimeout)
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					close(done)
+					r.RedisClient.ZRem(context.Background(), key, id)
+				})
+			}, nil
+		}
+		if !sleep(ctx, r.PollInterval) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// renewSlot keeps the slot from expiring until done is closed.
+func (r *RedisLimiter) renewSlot(done chan struct{}, key, id string, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			expiry := time.Now().Add(timeout)
+			renewSlotScript.Run(context.Background(), r.RedisClient, []string{key}, milliseconds(expiry), id)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *RedisLimiter) takeToken(ctx context.Context, message *PersistedEvent, limit Limit) error {
+	key := fmt.Sprintf("%s:%s:rate", r.Prefix, message.Type())
+	for {
+		wait, err := rateScript.Run(ctx, r.RedisClient, []string{key}, limit.Rate, limit.burst(), milliseconds(time.Now())).Int64()
+		if err != nil {
+			return errors.Wrap(err, "failed to take rate limit token")
+		}
+		if wait == 0 {
+			return nil
+		}
+		if !sleep(ctx, time.Duration(wait)*time.Millisecond) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *RedisLimiter) populateDefaults() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.defaultLoaded {
+		return
+	}
+	if r.RedisClient == nil {
+		addr := "localhost:6379"
+		if os.Getenv("REDIS_ADDR") != "" {
+			addr = os.Getenv("REDIS_ADDR")
+		}
+		r.RedisClient = redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs: []string{addr},
+		})
+	}
+	if r.Prefix == "" {
+		r.Prefix = "{RedisLimiter}"
+	}
+	if r.PollInterval == 0 {
+		r.PollInterval = 100 * time.Millisecond
+	}
+	r.defaultLoaded = true
+}
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
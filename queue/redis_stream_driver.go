@@ -0,0 +1,650 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// claimPageSize is the number of pending entries inspected per XPENDING call.
+const claimPageSize = 100
+
+// promoteScript moves the due messages from the delayed sorted set to the stream.
+// KEYS: delayed, stream. ARGV: now, count.
+var promoteScript = redis.NewScript(`
+local jobs = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, job in ipairs(jobs) do
+	redis.call('ZREM', KEYS[1], job)
+	redis.call('XADD', KEYS[2], '*', 'message', job)
+end
+return #jobs
+`)
+
+// discardScript moves a pending entry which can't be decoded to the failed list. Only the consumer acknowledging
+// the entry moves it, so that it is not moved twice. KEYS: stream, failed. ARGV: group, id, data.
+var discardScript = redis.NewScript(`
+if redis.call('XACK', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('XDEL', KEYS[1], ARGV[2])
+if ARGV[3] ~= '' then
+	redis.call('LPUSH', KEYS[2], ARGV[3])
+end
+return 1
+`)
+
+// reloadScript moves messages from the consuming end of a list to the stream. It returns the number of messages
+// moved. KEYS: list, stream. ARGV: count.
+var reloadScript = redis.NewScript(`
+local count = 0
+while count < tonumber(ARGV[1]) do
+	local job = redis.call('RPOP', KEYS[1])
+	if not job then
+		break
+	end
+	redis.call('XADD', KEYS[2], '*', 'message', job)
+	count = count + 1
+end
+return count
+`)
+
+// RedisStreamDriver is a queue driver backed by redis streams. Waiting messages are stored in a stream, and read by
+// a consumer group, so that each message is delivered to one consumer only. Delivered messages stay in the pending
+// entries list of the group until they are acknowledged.
+//
+// If a handler doesn't finish within the HandleTimeout, for example because the consumer crashed, its message is
+// claimed by another consumer and delivered again. Once a message has been delivered MaxAttempts times, it is put
+// onto the timeout channel instead.
+//
+// The waiting channel (ChannelConfig.Waiting) is the stream key. The delayed channel is a sorted set, and the failed
+// and timeout channels are lists, as in RedisDriver. Priority lanes and deduplication are not supported.
+type RedisStreamDriver struct {
+	Logger        log.Logger            // Logger is an optional logger. By default a noop logger is used
+	RedisClient   redis.UniversalClient // RedisClient is used to communicate with redis
+	ChannelConfig ChannelConfig         // ChannelConfig holds the name of redis keys for all queues. Reserved is unused.
+	Group         string                // Group is the name of the consumer group. Defaults to "queue".
+	Consumer      string                // Consumer is the name of this consumer in the group. Defaults to the hostname with a random suffix. It is removed from the group by Close.
+	PopTimeout    time.Duration         // PopTimeout is the XREADGROUP timeout. ie. How long the pop action will block at most.
+	ClaimInterval time.Duration         // ClaimInterval is how often due delayed messages are moved to the stream, and timed out messages are claimed.
+	Packer        Packer                // Packer describes how to save the message in wire format
+
+	lock          sync.Mutex
+	defaultLoaded bool
+	groupReady    bool
+	lastClaim     time.Time
+	claimed       []*PersistedEvent
+	ids           map[*PersistedEvent]string
+}
+
+// Push pushes the message onto the queue. It is possible to specify a time delay. If so the message
+// will be read after the delay. Use zero value if a delay is not needed.
+func (r *RedisStreamDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	r.populateDefaults()
+	data, err := r.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	if delay <= time.Duration(0) {
+		if err := r.add(ctx, r.RedisClient, data).Err(); err != nil {
+			return errors.Wrap(err, "failed to xadd while pushing")
+		}
+		return nil
+	}
+	_, err = r.RedisClient.ZAdd(ctx, r.ChannelConfig.Delayed, &redis.Z{
+		Score:  float64(time.Now().Add(delay).Unix()),
+		Member: data,
+	}).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to zadd while pushing")
+	}
+	return nil
+}
+
+// Pop pops the message out of the queue. It uses XREADGROUP underneath, so effectively it blocks until a
+// message is available or a timeout is reached. Messages claimed from other consumers are returned first.
+func (r *RedisStreamDriver) Pop(ctx context.Context) (*PersistedEvent, error) {
+	r.populateDefaults()
+	if err := r.createGroup(ctx); err != nil {
+		return nil, err
+	}
+	if r.claimDue() {
+		if err := r.promote(ctx); err != nil {
+			return nil, err
+		}
+		if err := r.claim(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if message := r.nextClaimed(); message != nil {
+		return message, nil
+	}
+
+	res, err := r.RedisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    r.Group,
+		Consumer: r.Consumer,
+		Streams:  []string{r.ChannelConfig.Waiting, ">"},
+		Count:    1,
+		Block:    r.PopTimeout,
+	}).Result()
+	if err == redis.Nil {
+		return nil, ErrEmpty
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+		// The stream has been flushed. The group will be created again on next pop.
+		r.lock.Lock()
+		r.groupReady = false
+		r.lock.Unlock()
+		return nil, ErrEmpty
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to XReadGroup while popping")
+	}
+	if len(res) == 0 || len(res[0].Messages) == 0 {
+		return nil, ErrEmpty
+	}
+	message, err := r.decode(res[0].Messages[0])
+	if err != nil {
+		return nil, err
+	}
+	r.reserve(message, res[0].Messages[0].ID)
+	return message, nil
+}
+
+// Ack acknowledges a message has been processed.
+func (r *RedisStreamDriver) Ack(ctx context.Context, message *PersistedEvent) error {
+	r.populateDefaults()
+	p := r.RedisClient.TxPipeline()
+	r.done(ctx, p, message)
+	if _, err := p.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to xack while acknowledging message")
+	}
+	return nil
+}
+
+// Fail marks a message has failed.
+func (r *RedisStreamDriver) Fail(ctx context.Context, message *PersistedEvent) error {
+	r.populateDefaults()
+	p := r.RedisClient.TxPipeline()
+	r.done(ctx, p, message)
+	message.Attempts++
+	data, err := r.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	p.LPush(ctx, r.ChannelConfig.Failed, data)
+	if _, err := p.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to lpush while failing message")
+	}
+	return nil
+}
+
+// Retry put the message back onto the delayed queue. The message will be tried after a period of time specified
+// by Backoff. Note: if one listener failed, all listeners for this event will have to be retried. Make sure
+// your listeners are idempotent as always.
+func (r *RedisStreamDriver) Retry(ctx context.Context, message *PersistedEvent) error {
+	r.populateDefaults()
+	p := r.RedisClient.TxPipeline()
+	r.done(ctx, p, message)
+	delay := time.Now().Add(nextBackoff(message))
+	message.Attempts++
+	data, err := r.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	p.ZAdd(ctx, r.ChannelConfig.Delayed, &redis.Z{
+		Score:  float64(delay.Unix()),
+		Member: data,
+	})
+	if _, err := p.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to add zset while retrying")
+	}
+	return nil
+}
+
+// Release puts a reserved message back onto the stream right away, without counting an attempt. Unlike
+// RedisDriver, the message is put at the end of the stream. It implements Releaser.
+func (r *RedisStreamDriver) Release(ctx context.Context, message *PersistedEvent) error {
+	r.populateDefaults()
+	data, err := r.Packer.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress message")
+	}
+	p := r.RedisClient.TxPipeline()
+	r.done(ctx, p, message)
+	r.add(ctx, p, data)
+	if _, err := p.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to release message")
+	}
+	return nil
+}
+
+// Extend resets the idle time of a reserved message, so that it won't be claimed by other consumers within the
+// HandleTimeout. As pending messages are claimed by their idle time, the timeout argument is ignored. It implements
+// Extender.
+func (r *RedisStreamDriver) Extend(ctx context.Context, message *PersistedEvent, timeout time.Duration) error {
+	r.populateDefaults()
+	r.lock.Lock()
+	id, ok := r.ids[message]
+	r.lock.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	// The message may have been claimed by another consumer already.
+	pending, err := r.RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   r.ChannelConfig.Waiting,
+		Group:    r.Group,
+		Start:    id,
+		End:      id,
+		Count:    1,
+		Consumer: r.Consumer,
+	}).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to extend reservation")
+	}
+	if len(pending) == 0 {
+		return ErrNotFound
+	}
+	// JUSTID doesn't increase the delivery count.
+	claimed, err := r.RedisClient.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   r.ChannelConfig.Waiting,
+		Group:    r.Group,
+		Consumer: r.Consumer,
+		Messages: []string{id},
+	}).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to extend reservation")
+	}
+	if len(claimed) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reload put failed/timeout message back to the Waiting queue. If the temporary outage have been cleared,
+// messages can be tried again via Reload. Reload is not a normal retry.
+// It similarly gives otherwise dead messages one more chance,
+// but this chance is not subject to the limit of MaxAttempts, nor does it reset the number of time attempted.
+func (r *RedisStreamDriver) Reload(ctx context.Context, channel string) (int64, error) {
+	r.populateDefaults()
+	channel = r.channel(channel)
+	if channel != r.ChannelConfig.Failed && channel != r.ChannelConfig.Timeout {
+		return 0, fmt.Errorf("reloading %s is not allowed", channel)
+	}
+	const batchSize = 100
+	var count int64 = 0
+	for {
+		moved, err := reloadScript.Run(ctx, r.RedisClient, []string{channel, r.ChannelConfig.Waiting}, batchSize).Int64()
+		count += moved
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to move %s to stream while reloading", channel)
+		}
+		if moved < batchSize {
+			return count, nil
+		}
+	}
+}
+
+// Flush flushes a queue of choice by deleting all its data. Use with caution. Flushing the waiting channel
+// deletes the stream along with the consumer group, including the messages being handled.
+func (r *RedisStreamDriver) Flush(ctx context.Context, channel string) error {
+	r.populateDefaults()
+	channel = r.channel(channel)
+	if channel == r.ChannelConfig.Reserved {
+		return fmt.Errorf("flushing %s is not allowed, flush the waiting channel instead", channel)
+	}
+	if _, err := r.RedisClient.Del(ctx, channel).Result(); err != nil {
+		return errors.Wrapf(err, "failed to flush %s", channel)
+	}
+	if channel == r.ChannelConfig.Waiting {
+		r.lock.Lock()
+		r.groupReady = false
+		r.claimed = nil
+		r.lock.Unlock()
+	}
+	return nil
+}
+
+// Info lists QueueInfo by inspecting queues one by one. Useful for metrics and monitor. The messages being
+// handled are not counted as waiting.
+func (r *RedisStreamDriver) Info(ctx context.Context) (QueueInfo, error) {
+	r.populateDefaults()
+	var (
+		oneByOne attempt
+		info     QueueInfo
+	)
+	oneByOne.try(r.RedisClient.XLen(ctx, r.ChannelConfig.Waiting), &info.Waiting)
+	oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Failed), &info.Failed)
+	oneByOne.try(r.RedisClient.LLen(ctx, r.ChannelConfig.Timeout), &info.Timeout)
+	oneByOne.try(r.RedisClient.ZCard(ctx, r.ChannelConfig.Delayed), &info.Delayed)
+	if oneByOne.err != nil {
+		return info, errors.Wrap(oneByOne.err, "failed to collect queue info")
+	}
+
+	if info.Waiting == 0 {
+		return info, nil
+	}
+	groups, err := r.RedisClient.XInfoGroups(ctx, r.ChannelConfig.Waiting).Result()
+	if err != nil {
+		return info, errors.Wrap(err, "failed to collect queue info")
+	}
+	for _, group := range groups {
+		if group.Name == r.Group {
+			info.Waiting -= group.Pending
+		}
+	}
+	return info, nil
+}
+
+// Consumers lists the consumers in the group, with the number of messages each of them is handling and how long
+// it has been idle. A consumer which stays idle for long may have crashed. The list comes from XINFO CONSUMERS.
+func (r *RedisStreamDriver) Consumers(ctx context.Context) ([]redis.XInfoConsumer, error) {
+	r.populateDefaults()
+	// XInfoConsumers is missing from redis.UniversalClient.
+	cmd := redis.NewXInfoConsumersCmd(ctx, r.ChannelConfig.Waiting, r.Group)
+	_ = r.RedisClient.Process(ctx, cmd)
+	consumers, err := cmd.Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list consumers")
+	}
+	return consumers, nil
+}
+
+// Close removes the consumer from the group with XGROUP DELCONSUMER, so that the random default names don't pile
+// up in the group. If the consumer still has pending messages, it is kept, as deleting it would drop them from the
+// pending entries list, and they would never be claimed again. The RedisClient is not closed.
+func (r *RedisStreamDriver) Close() error {
+	r.populateDefaults()
+	ctx := context.Background()
+	pending, err := r.RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   r.ChannelConfig.Waiting,
+		Group:    r.Group,
+		Start:    "-",
+		End:      "+",
+		Count:    1,
+		Consumer: r.Consumer,
+	}).Result()
+	if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to list pending messages while closing")
+	}
+	if len(pending) > 0 {
+		return nil
+	}
+	if err := r.RedisClient.XGroupDelConsumer(ctx, r.ChannelConfig.Waiting, r.Group, r.Consumer).Err(); err != nil {
+		return errors.Wrap(err, "failed to delete consumer")
+	}
+	return nil
+}
+
+// createGroup creates the consumer group, if it hasn't been created by this or another consumer.
+func (r *RedisStreamDriver) createGroup(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.groupReady {
+		return nil
+	}
+	err := r.RedisClient.XGroupCreateMkStream(ctx, r.ChannelConfig.Waiting, r.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrap(err, "failed to create consumer group")
+	}
+	r.groupReady = true
+	return nil
+}
+
+// claimDue reports whether ClaimInterval has passed since the last claim.
+func (r *RedisStreamDriver) claimDue() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.lastClaim) < r.ClaimInterval {
+		return false
+	}
+	r.lastClaim = time.Now()
+	return true
+}
+
+// promote moves the due messages from the delayed channel to the stream.
+func (r *RedisStreamDriver) promote(ctx context.Context) error {
+	keys := []string{r.ChannelConfig.Delayed, r.ChannelConfig.Waiting}
+	if err := promoteScript.Run(ctx, r.RedisClient, keys, time.Now().Unix(), 100).Err(); err != nil {
+		return errors.Wrap(err, "failed to move delayed messages")
+	}
+	return nil
+}
+
+// claim takes over the pending messages whose HandleTimeout has passed. Messages already delivered MaxAttempts
+// times are moved to the timeout channel, and messages which can't be decoded to the failed channel. The others are
+// kept to be returned by Pop. The pending entries list is read in pages of claimPageSize.
+func (r *RedisStreamDriver) claim(ctx context.Context) error {
+	start := "-"
+	for {
+		pending, err := r.RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: r.ChannelConfig.Waiting,
+			Group:  r.Group,
+			Start:  start,
+			End:    "+",
+			Count:  claimPageSize,
+		}).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to list pending messages")
+		}
+		if err := r.claimPage(ctx, pending); err != nil {
+			return err
+		}
+		if len(pending) < claimPageSize {
+			return nil
+		}
+		if start, err = nextStreamID(pending[len(pending)-1].ID); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *RedisStreamDriver) claimPage(ctx context.Context, pending []redis.XPendingExt) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	// The handle timeout is stored in the message, so the whole page is read in one round trip.
+	p := r.RedisClient.Pipeline()
+	ranges := make([]*redis.XMessageSliceCmd, len(pending))
+	for i, entry := range pending {
+		ranges[i] = p.XRangeN(ctx, r.ChannelConfig.Waiting, entry.ID, entry.ID, 1)
+	}
+	if _, err := p.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to read pending messages")
+	}
+	for i, entry := range pending {
+		entries := ranges[i].Val()
+		if len(entries) == 0 {
+			continue
+		}
+		message, err := r.decode(entries[0])
+		if err != nil {
+			if err := r.discard(ctx, entries[0]); err != nil {
+				return err
+			}
+			_ = level.Warn(r.Logger).Log("err", errors.Wrapf(err, "moved pending message %s to the failed channel", entry.ID))
+			continue
+		}
+		if entry.Idle < message.HandleTimeout {
+			continue
+		}
+		// Only one consumer can claim the message, as the idle time is reset by XCLAIM.
+		claimed, err := r.RedisClient.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   r.ChannelConfig.Waiting,
+			Group:    r.Group,
+			Consumer: r.Consumer,
+			MinIdle:  message.HandleTimeout,
+			Messages: []string{entry.ID},
+		}).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to claim pending message")
+		}
+		if len(claimed) == 0 {
+			continue
+		}
+		r.reserve(message, entry.ID)
+		if entry.RetryCount < int64(message.MaxAttempts) {
+			r.lock.Lock()
+			r.claimed = append(r.claimed, message)
+			r.lock.Unlock()
+			continue
+		}
+		p := r.RedisClient.TxPipeline()
+		r.done(ctx, p, message)
+		p.LPush(ctx, r.ChannelConfig.Timeout, entries[0].Values["message"])
+		if _, err := p.Exec(ctx); err != nil {
+			return errors.Wrap(err, "failed to move message to timeout queue")
+		}
+	}
+	return nil
+}
+
+// discard moves an entry which can't be decoded to the failed channel. Otherwise it would stay pending forever.
+func (r *RedisStreamDriver) discard(ctx context.Context, entry redis.XMessage) error {
+	data, _ := entry.Values["message"].(string)
+	keys := []string{r.ChannelConfig.Waiting, r.ChannelConfig.Failed}
+	if err := discardScript.Run(ctx, r.RedisClient, keys, r.Group, entry.ID, data).Err(); err != nil {
+		return errors.Wrap(err, "failed to move malformed message to failed queue")
+	}
+	return nil
+}
+
+func (r *RedisStreamDriver) nextClaimed() *PersistedEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.claimed) == 0 {
+		return nil
+	}
+	message := r.claimed[0]
+	r.claimed = r.claimed[1:]
+	return message
+}
+
+// reserve remembers the stream id of the message, until it is acknowledged.
+func (r *RedisStreamDriver) reserve(message *PersistedEvent, id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids[message] = id
+}
+
+// done queues the removal of the message from the stream in the pipeline.
+func (r *RedisStreamDriver) done(ctx context.Context, p redis.Pipeliner, message *PersistedEvent) {
+	r.lock.Lock()
+	id, ok := r.ids[message]
+	delete(r.ids, message)
+	r.lock.Unlock()
+	if !ok {
+		return
+	}
+	p.XAck(ctx, r.ChannelConfig.Waiting, r.Group, id)
+	p.XDel(ctx, r.ChannelConfig.Waiting, id)
+}
+
+// nextStreamID returns the smallest stream ID greater than id.
+func nextStreamID(id string) (string, error) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return "", fmt.Errorf("malformed stream id %s", id)
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", errors.Wrapf(err, "malformed stream id %s", id)
+	}
+	return id[:i+1] + strconv.FormatUint(seq+1, 10), nil
+}
+
+func (r *RedisStreamDriver) add(ctx context.Context, c redis.Cmdable, data []byte) *redis.StringCmd {
+	return c.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.ChannelConfig.Waiting,
+		Values: []interface{}{"message", data},
+	})
+}
+
+func (r *RedisStreamDriver) decode(entry redis.XMessage) (*PersistedEvent, error) {
+	data, ok := entry.Values["message"].(string)
+	if !ok {
+		return nil, fmt.Errorf("malformed stream entry %s", entry.ID)
+	}
+	var message PersistedEvent
+	if err := r.Packer.Unmarshal([]byte(data), &message); err != nil {
+		return nil, errors.Wrap(err, "failed to decompress message")
+	}
+	return &message, nil
+}
+
+// channel resolves plain channel names to redis keys. Other names are returned as is.
+func (r *RedisStreamDriver) channel(name string) string {
+	switch name {
+	case channelWaiting:
+		return r.ChannelConfig.Waiting
+	case channelDelayed:
+		return r.ChannelConfig.Delayed
+	case channelReserved:
+		return r.ChannelConfig.Reserved
+	case channelTimeout:
+		return r.ChannelConfig.Timeout
+	case channelFailed:
+		return r.ChannelConfig.Failed
+	}
+	return name
+}
+
+func (r *RedisStreamDriver) populateDefaults() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.defaultLoaded {
+		return
+	}
+	if r.RedisClient == nil {
+		addr := "localhost:6379"
+		if os.Getenv("REDIS_ADDR") != "" {
+			addr = os.Getenv("REDIS_ADDR")
+		}
+		r.RedisClient = redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs: []string{addr},
+		})
+	}
+	if r.Packer == nil {
+		r.Packer = packer{}
+	}
+	if r.Logger == nil {
+		r.Logger = log.NewNopLogger()
+	}
+	var empty ChannelConfig
+	if r.ChannelConfig == empty {
+		r.ChannelConfig = ChannelConfig{
+			Delayed:  "{RedisStreamDriver}:delayed",
+			Failed:   "{RedisStreamDriver}:failed",
+			Reserved: "{RedisStreamDriver}:reserved",
+			Waiting:  "{RedisStreamDriver}:waiting",
+			Timeout:  "{RedisStreamDriver}:timeout",
+		}
+	}
+	if r.Group == "" {
+		r.Group = "queue"
+	}
+	if r.Consumer == "" {
+		hostname, _ := os.Hostname()
+		r.Consumer = hostname + "-" + randomId()
+	}
+	if r.PopTimeout == time.Duration(0) {
+		r.PopTimeout = time.Second
+	}
+	if r.ClaimInterval == time.Duration(0) {
+		r.ClaimInterval = time.Second
+	}
+	r.ids = make(map[*PersistedEvent]string)
+	r.defaultLoaded = true
+}
@@ -0,0 +1,148 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/queue"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStreamDriver(t *testing.T) {
+	ctx := context.Background()
+	driver := &queue.RedisStreamDriver{PopTimeout: 10 * time.Millisecond}
+	for _, channel := range []string{"waiting", "delayed", "failed", "timeout"} {
+		driver.Flush(ctx, channel)
+		defer driver.Flush(ctx, channel)
+	}
+
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{UniqueId: "foo", MaxAttempts: 1}, 0))
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{UniqueId: "bar", MaxAttempts: 1}, time.Hour))
+	info, err := driver.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, queue.QueueInfo{Waiting: 1, Delayed: 1}, info)
+
+	message, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", message.UniqueId)
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, int64(0), info.Waiting)
+
+	consumers, err := driver.Consumers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, consumers, 1)
+	assert.Equal(t, int64(1), consumers[0].Pending)
+
+	assert.NoError(t, driver.Fail(ctx, message))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{Delayed: 1, Failed: 1}, info)
+
+	count, err := driver.Reload(ctx, "failed")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	message, err = driver.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, message.Attempts)
+	assert.NoError(t, driver.Ack(ctx, message))
+	info, _ = driver.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{Delayed: 1}, info)
+}
+
+func TestRedisStreamDriver_claim(t *testing.T) {
+	ctx := context.Background()
+	crashed := &queue.RedisStreamDriver{PopTimeout: 10 * time.Millisecond}
+	alive := &queue.RedisStreamDriver{PopTimeout: 10 * time.Millisecond, ClaimInterval: time.Millisecond}
+	for _, channel := range []string{"waiting", "timeout"} {
+		crashed.Flush(ctx, channel)
+		defer crashed.Flush(ctx, channel)
+	}
+
+	crashed.Push(ctx, &queue.PersistedEvent{UniqueId: "foo", HandleTimeout: 50 * time.Millisecond, MaxAttempts: 2}, 0)
+	_, err := crashed.Pop(ctx)
+	assert.NoError(t, err)
+
+	_, err = alive.Pop(ctx)
+	assert.ErrorIs(t, err, queue.ErrEmpty)
+	time.Sleep(100 * time.Millisecond)
+	message, err := alive.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", message.UniqueId)
+
+	// The message has been delivered twice, so it is moved to the timeout channel.
+	time.Sleep(100 * time.Millisecond)
+	_, err = alive.Pop(ctx)
+	assert.ErrorIs(t, err, queue.ErrEmpty)
+	info, _ := alive.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{Timeout: 1}, info)
+}
+
+func TestRedisStreamDriver_claimPages(t *testing.T) {
+	ctx := context.Background()
+	crashed := &queue.RedisStreamDriver{PopTimeout: 10 * time.Millisecond}
+	alive := &queue.RedisStreamDriver{PopTimeout: 10 * time.Millisecond, ClaimInterval: time.Millisecond}
+	crashed.Flush(ctx, "waiting")
+	defer crashed.Flush(ctx, "waiting")
+
+	// More messages than a single XPENDING page.
+	for i := 0; i < 150; i++ {
+		assert.NoError(t, crashed.Push(ctx, &queue.PersistedEvent{HandleTimeout: 50 * time.Millisecond, MaxAttempts: 2}, 0))
+		_, err := crashed.Pop(ctx)
+		assert.NoError(t, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 150; i++ {
+		message, err := alive.Pop(ctx)
+		assert.NoError(t, err)
+		assert.NoError(t, alive.Ack(ctx, message))
+	}
+	info, _ := alive.Info(ctx)
+	assert.Equal(t, queue.QueueInfo{}, info)
+}
+
+func TestRedisStreamDriver_corrupt(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{os.Getenv("REDIS_ADDR")}})
+	driver := &queue.RedisStreamDriver{RedisClient: client, PopTimeout: 10 * time.Millisecond, ClaimInterval: time.Millisecond}
+	for _, channel := range []string{"waiting", "failed"} {
+		driver.Flush(ctx, channel)
+		defer driver.Flush(ctx, channel)
+	}
+
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{UniqueId: "foo", HandleTimeout: time.Hour, MaxAttempts: 1}, 0))
+	_, _ = driver.Pop(ctx)
+	client.XAdd(ctx, &redis.XAddArgs{Stream: "{RedisStreamDriver}:waiting", Values: []interface{}{"message", "corrupt"}})
+	_, err := driver.Pop(ctx)
+	assert.Error(t, err)
+
+	// The pending entry can't be decoded, so it is moved to the failed channel when claiming.
+	time.Sleep(10 * time.Millisecond)
+	_, err = driver.Pop(ctx)
+	assert.ErrorIs(t, err, queue.ErrEmpty)
+	info, _ := driver.Info(ctx)
+	assert.Equal(t, int64(1), info.Failed)
+}
+
+func TestRedisStreamDriver_Close(t *testing.T) {
+	ctx := context.Background()
+	driver := &queue.RedisStreamDriver{PopTimeout: 10 * time.Millisecond}
+	driver.Flush(ctx, "waiting")
+	defer driver.Flush(ctx, "waiting")
+
+	assert.NoError(t, driver.Push(ctx, &queue.PersistedEvent{UniqueId: "foo"}, 0))
+	message, err := driver.Pop(ctx)
+	assert.NoError(t, err)
+
+	// The consumer is kept while it has pending messages.
+	assert.NoError(t, driver.Close())
+	consumers, _ := driver.Consumers(ctx)
+	assert.Len(t, consumers, 1)
+
+	assert.NoError(t, driver.Ack(ctx, message))
+	assert.NoError(t, driver.Close())
+	consumers, _ = driver.Consumers(ctx)
+	assert.Len(t, consumers, 0)
+}
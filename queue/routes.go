@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/DoNewsCode/core/contract"
+)
+
+// routable is implemented by persistent events that choose their queue. See OnQueue.
+type routable interface {
+	Queue() string
+}
+
+// UseRoutes is an option for WithQueue that forwards persistent events to other queues made by the factory. The
+// routes map event types, such as "github.com/foo/mail.SendEvent", or packages, such as "github.com/foo/mail", to
+// queue names. Event types take precedence over packages, and the OnQueue option takes precedence over both.
+//
+// Listeners only need to be subscribed once, on any of the dispatchers made by the factory. The event types are
+// shared with the others, so that the consumers of each queue can decode the events routed to them.
+func UseRoutes(factory DispatcherFactory, routes map[string]string) func(*QueueableDispatcher) {
+	return func(dispatcher *QueueableDispatcher) {
+		dispatcher.factory = factory
+		dispatcher.routes = routes
+	}
+}
+
+// route returns the dispatcher of the queue the persistent event belongs to.
+func (d *QueueableDispatcher) route(e contract.Event) (*QueueableDispatcher, error) {
+	var name string
+	if r, ok := e.(routable); ok {
+		name = r.Queue()
+	}
+	if name == "" {
+		name = d.routes[e.Type()]
+	}
+	if name == "" {
+		if i := strings.LastIndex(e.Type(), "."); i > 0 {
+			name = d.routes[e.Type()[:i]]
+		}
+	}
+	if name == "" {
+		return d, nil
+	}
+	if d.factory.Factory == nil {
+		return nil, fmt.Errorf("queue %s is unknown to the dispatcher, see UseRoutes", name)
+	}
+	target, err := d.factory.Make(name)
+	if err != nil {
+		return nil, err
+	}
+	if target != d && target.reflectType(e.Type()) == nil {
+		// The producer may not have subscribed the event on the target.
+		target.learn([]contract.Event{e})
+	}
+	return target, nil
+}
+
+// learn records the types of the events, so that they can be decoded. If routes are used, the types are shared with
+// the other dispatchers made by the factory.
+func (d *QueueableDispatcher) learn(evts []contract.Event) {
+	d.register(evts)
+	if d.factory.Factory == nil {
+		return
+	}
+	for _, pair := range d.factory.List() {
+		other, ok := pair.Conn.(*QueueableDispatcher)
+		if !ok || other == d {
+			continue
+		}
+		other.register(evts)
+	}
+}
+
+// register records the types of the events unknown to the dispatcher. The write lock is only taken if there are
+// any, so that known types don't hold up the dispatchers decoding events. Events without data, such as
+// events.Pattern, are skipped, as there is no type to decode them to.
+func (d *QueueableDispatcher) register(evts []contract.Event) {
+	var missing []contract.Event
+	d.rwLock.RLock()
+	for _, e := range evts {
+		if e.Data() == nil {
+			continue
+		}
+		if _, ok := d.reflectTypes[e.Type()]; !ok {
+			missing = append(missing, e)
+		}
+	}
+	d.rwLock.RUnlock()
+	if len(missing) == 0 {
+		return
+	}
+	d.rwLock.Lock()
+	for _, e := range missing {
+		d.reflectTypes[e.Type()] = reflect.TypeOf(e.Data())
+	}
+	d.rwLock.Unlock()
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/di"
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnotherMockEvent struct {
+	Value string
+}
+
+func setUpRoutes(routes map[string]string) (DispatcherFactory, map[string]*InProcessDriver) {
+	drivers := map[string]*InProcessDriver{
+		"default": NewInProcessDriverWithPopInterval(time.Millisecond),
+		"emails":  NewInProcessDriverWithPopInterval(time.Millisecond),
+	}
+	var factory DispatcherFactory
+	base := &events.SyncDispatcher{}
+	factory.Factory = di.NewFactory(func(name string) (di.Pair, error) {
+		return di.Pair{Conn: WithQueue(base, drivers[name], UseRoutes(factory, routes))}, nil
+	})
+	factory.Make("default")
+	factory.Make("emails")
+	return factory, drivers
+}
+
+func TestDispatcher_routes(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name   string
+		routes map[string]string
+		event  contract.Event
+		queue  string
+	}{
+		{"no route", nil, Persist(events.Of(MockEvent{})), "default"},
+		{"type", map[string]string{events.Of(MockEvent{}).Type(): "emails"}, Persist(events.Of(MockEvent{})), "emails"},
+		{"package", map[string]string{"github.com/DoNewsCode/core/queue": "emails"}, Persist(events.Of(MockEvent{})), "emails"},
+		{"other type", map[string]string{events.Of(MockEvent{}).Type(): "emails"}, Persist(events.Of(AnotherMockEvent{})), "default"},
+		{"on queue", map[string]string{events.Of(MockEvent{}).Type(): "default"}, Persist(events.Of(MockEvent{}), OnQueue("emails")), "emails"},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			factory, drivers := setUpRoutes(c.routes)
+			dispatcher, _ := factory.Make("default")
+			assert.NoError(t, dispatcher.Dispatch(ctx, c.event))
+			for name, driver := range drivers {
+				info, _ := driver.Info(ctx)
+				if name == c.queue {
+					assert.Equal(t, int64(1), info.Waiting, name)
+				} else {
+					assert.Equal(t, int64(0), info.Waiting, name)
+				}
+			}
+		})
+	}
+
+	_, err := WithQueue(&events.SyncDispatcher{}, NewInProcessDriver()).route(Persist(events.Of(MockEvent{}), OnQueue("emails")))
+	assert.Error(t, err)
+}
+
+func TestDispatcher_routesKnownType(t *testing.T) {
+	factory, _ := setUpRoutes(map[string]string{events.Of(MockEvent{}).Type(): "emails"})
+	dispatcher, _ := factory.Make("default")
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error { return nil }))
+
+	// Dispatching known types must not wait for the write lock of other dispatchers.
+	emails, _ := factory.Make("emails")
+	emails.rwLock.RLock()
+	defer emails.rwLock.RUnlock()
+	done := make(chan error)
+	go func() {
+		done <- dispatcher.Dispatch(context.Background(), Persist(events.Of(MockEvent{})))
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("dispatching is blocked")
+	}
+}
+
+func TestDispatcher_routesPattern(t *testing.T) {
+	factory, _ := setUpRoutes(map[string]string{events.Of(MockEvent{}).Type(): "emails"})
+	dispatcher, _ := factory.Make("default")
+	dispatcher.Subscribe(events.Listen([]contract.Event{events.Pattern("*")}, func(ctx context.Context, event contract.Event) error {
+		return nil
+	}))
+
+	emails, _ := factory.Make("emails")
+	for _, d := range []*QueueableDispatcher{dispatcher, emails} {
+		_, ok := d.reflectTypes["*"]
+		assert.False(t, ok)
+	}
+}
+
+func TestDispatcher_routesConsume(t *testing.T) {
+	factory, _ := setUpRoutes(map[string]string{events.Of(MockEvent{}).Type(): "emails"})
+	dispatcher, _ := factory.Make("default")
+	received := make(chan string)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		received <- event.Data().(MockEvent).Value
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emails, _ := factory.Make("emails")
+	go emails.Consume(ctx)
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "foo"}))))
+	assert.Equal(t, "foo", <-received)
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// scaler decides the number of workers for UseAdaptiveParallelism. Its methods are safe to call on nil.
+type scaler struct {
+	min      int
+	max      int
+	interval time.Duration
+
+	// The following fields are accessed atomically.
+	busy    int64
+	handled int64
+	elapsed int64
+	workers int64
+}
+
+// begin marks a handler has started.
+func (s *scaler) begin() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.busy, 1)
+}
+
+// end marks a handler has finished after the duration.
+func (s *scaler) end(duration time.Duration) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.busy, -1)
+	atomic.AddInt64(&s.handled, 1)
+	atomic.AddInt64(&s.elapsed, int64(duration))
+}
+
+// desired returns the number of workers needed, given the current workers and the length of the waiting channel.
+// The busy workers are kept, and more are added to handle the waiting events within an interval. If no handler has
+// finished since the last call, a worker is added for each waiting event. Workers are removed one at a time.
+func (s *scaler) desired(current int, waiting int64) int {
+	handled := atomic.SwapInt64(&s.handled, 0)
+	elapsed := atomic.SwapInt64(&s.elapsed, 0)
+	busy := int(atomic.LoadInt64(&s.busy))
+
+	need := float64(waiting)
+	if handled > 0 {
+		latency := float64(elapsed) / float64(handled)
+		need = math.Ceil(need * latency / float64(s.interval))
+	}
+	target := busy + int(math.Min(need, float64(s.max)))
+	if target < current {
+		target = current - 1
+	}
+	if target < s.min {
+		target = s.min
+	}
+	if target > s.max {
+		target = s.max
+	}
+	return target
+}
+
+func (s *scaler) setWorkers(n int) {
+	atomic.StoreInt64(&s.workers, int64(n))
+}
+
+func (s *scaler) getWorkers() int {
+	return int(atomic.LoadInt64(&s.workers))
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaler_desired(t *testing.T) {
+	cases := []struct {
+		name     string
+		busy     int64
+		handled  int64
+		elapsed  time.Duration
+		current  int
+		waiting  int64
+		expected int
+	}{
+		{"idle", 0, 0, 0, 1, 0, 1},
+		{"no samples", 1, 0, 0, 1, 3, 4},
+		{"fast handlers", 2, 10, 10 * time.Millisecond, 2, 100, 3},
+		{"slow handlers", 2, 10, 10 * time.Second, 2, 100, 10},
+		{"shrink one at a time", 0, 10, time.Second, 8, 0, 7},
+		{"keep busy workers", 5, 10, time.Second, 5, 0, 5},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			s := &scaler{min: 1, max: 10, interval: time.Second, busy: c.busy, handled: c.handled, elapsed: int64(c.elapsed)}
+			assert.Equal(t, c.expected, s.desired(c.current, c.waiting))
+		})
+	}
+}
+
+func TestDispatcher_adaptiveParallelism(t *testing.T) {
+	dispatcher := WithQueue(
+		&events.SyncDispatcher{},
+		NewInProcessDriverWithPopInterval(time.Millisecond),
+		UseAdaptiveParallelism(1, 4, 10*time.Millisecond),
+	)
+	var running, peak int64
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}))))
+	}
+	go dispatcher.Consume(ctx)
+
+	assert.Eventually(t, func() bool {
+		return dispatcher.scaler.getWorkers() == 4
+	}, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		info, _ := dispatcher.driver.Info(ctx)
+		return info.Waiting == 0 && dispatcher.scaler.getWorkers() == 1
+	}, 2*time.Second, time.Millisecond)
+	assert.Greater(t, atomic.LoadInt64(&peak), int64(1))
+}
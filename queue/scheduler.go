@@ -0,0 +1,182 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/leader"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis/v8"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// Locker makes sure only one instance in the cluster enqueues each occurrence of a scheduled event.
+type Locker interface {
+	// Lock returns true if the key is acquired by the caller. The key is unique to each occurrence, and the lock
+	// is never released explicitly. It only needs to live for the ttl.
+	Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// RedisLocker is a Locker backed by redis. Whoever sets the key first acquires it.
+type RedisLocker struct {
+	RedisClient redis.UniversalClient // RedisClient is used to communicate with redis
+	Prefix      string                // Prefix is prepended to the keys
+}
+
+// Lock implements Locker.
+func (r *RedisLocker) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if r.Prefix != "" {
+		key = r.Prefix + ":" + key
+	}
+	return r.RedisClient.SetNX(ctx, key, 1, ttl).Result()
+}
+
+// LeaderLocker is a Locker that only lets the leader elected by package leader enqueue.
+type LeaderLocker struct {
+	Status *leader.Status
+}
+
+// Lock implements Locker.
+func (l LeaderLocker) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return l.Status.IsLeader(), nil
+}
+
+// schedule is an event added to the Scheduler.
+type schedule struct {
+	name     string
+	schedule cron.Schedule
+	event    contract.Event
+	opts     []PersistOption
+	next     time.Time
+}
+
+// Scheduler dispatches persisted events periodically, by cron expressions. Unlike jobs in *cron.Cron, the events
+// go through the queue, so they are durable and can be retried. Many instances can run the same Scheduler. The
+// Locker ensures each occurrence is enqueued only once.
+//
+//  scheduler := queue.NewScheduler(dispatcher, &queue.RedisLocker{RedisClient: client})
+//  scheduler.Add("daily-report", "0 3 * * *", events.Of(ReportEvent{}), queue.MaxAttempts(3))
+//  c.AddModule(scheduler)
+type Scheduler struct {
+	Dispatcher contract.Dispatcher // Dispatcher should be the *QueueableDispatcher of the queue.
+	Locker     Locker              // Locker is used to coordinate the instances. If nil, every instance enqueues.
+	LockTTL    time.Duration       // LockTTL is how long the lock of each occurrence lives. By default, 10 minutes.
+	Logger     log.Logger          // Logger is an optional logger. By default a noop logger is used
+
+	schedules []*schedule
+}
+
+// NewScheduler creates a Scheduler.
+func NewScheduler(dispatcher contract.Dispatcher, locker Locker) *Scheduler {
+	return &Scheduler{Dispatcher: dispatcher, Locker: locker}
+}
+
+// Add schedules the event by the cron expression. Seconds are optional, and descriptors such as "@hourly" and
+// "@every 5m" are accepted. The name must be unique in the Scheduler, as it identifies the occurrences across
+// instances. Each occurrence is given the UniqueId "name:timestamp". Add must be called before Run.
+func (s *Scheduler) Add(name, spec string, event contract.Event, opts ...PersistOption) error {
+	parser := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	sched, err := parser.Parse(spec)
+	if err != nil {
+		return errors.Wrapf(err, "invalid schedule %s", name)
+	}
+	for _, existing := range s.schedules {
+		if existing.name == name {
+			return fmt.Errorf("schedule %s already exists", name)
+		}
+	}
+	s.schedules = append(s.schedules, &schedule{name: name, schedule: sched, event: event, opts: opts})
+	return nil
+}
+
+// Run dispatches the scheduled events until the context is canceled. Occurrences missed while not running are
+// skipped.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.Logger == nil {
+		s.Logger = log.NewNopLogger()
+	}
+	now := time.Now()
+	for _, sched := range s.schedules {
+		sched.next = next(sched.schedule, now)
+	}
+	for {
+		timer := time.NewTimer(s.wait(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case now = <-timer.C:
+		}
+		for _, sched := range s.schedules {
+			if sched.next.After(now) {
+				continue
+			}
+			s.enqueue(ctx, sched, sched.next)
+			sched.next = next(sched.schedule, sched.next)
+			if sched.next.Before(now) {
+				sched.next = next(sched.schedule, now)
+			}
+		}
+	}
+}
+
+// ProvideRunGroup implements container.RunProvider.
+func (s *Scheduler) ProvideRunGroup(group *run.Group) {
+	ctx, cancel := context.WithCancel(context.Background())
+	group.Add(func() error {
+		return s.Run(ctx)
+	}, func(err error) {
+		cancel()
+	})
+}
+
+// wait returns the duration until the earliest occurrence.
+func (s *Scheduler) wait(now time.Time) time.Duration {
+	if len(s.schedules) == 0 {
+		return time.Hour
+	}
+	earliest := s.schedules[0].next
+	for _, sched := range s.schedules[1:] {
+		if sched.next.Before(earliest) {
+			earliest = sched.next
+		}
+	}
+	return earliest.Sub(now)
+}
+
+// enqueue dispatches the occurrence of the schedule, if the lock is acquired.
+func (s *Scheduler) enqueue(ctx context.Context, sched *schedule, at time.Time) {
+	uniqueId := fmt.Sprintf("%s:%d", sched.name, at.Unix())
+	if s.Locker != nil {
+		ttl := s.LockTTL
+		if ttl == 0 {
+			ttl = 10 * time.Minute
+		}
+		ok, err := s.Locker.Lock(ctx, uniqueId, ttl)
+		if err != nil {
+			_ = level.Warn(s.Logger).Log("err", errors.Wrapf(err, "failed to lock schedule %s, skipping", uniqueId))
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	opts := append(append([]PersistOption{}, sched.opts...), UniqueId(uniqueId))
+	if err := s.Dispatcher.Dispatch(ctx, Persist(sched.event, opts...)); err != nil {
+		_ = level.Warn(s.Logger).Log("err", errors.Wrapf(err, "failed to dispatch schedule %s", uniqueId))
+	}
+}
+
+// next returns the next occurrence after t. Schedules of "@every" are aligned to multiples of the delay, rather
+// than relative to the start, so that all instances agree on the occurrences.
+func next(sched cron.Schedule, t time.Time) time.Time {
+	if every, ok := sched.(cron.ConstantDelaySchedule); ok {
+		return t.Truncate(every.Delay).Add(every.Delay)
+	}
+	return sched.Next(t)
+}
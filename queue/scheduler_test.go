@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockLocker struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func (m *mockLocker) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[key]; ok {
+		return false, nil
+	}
+	m.keys[key] = struct{}{}
+	return true, nil
+}
+
+func TestScheduler(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		uniqueIds []string
+	)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, NewInProcessDriverWithPopInterval(time.Millisecond), UseParallelism(1), UseMiddleware(
+		func(next Handler) Handler {
+			return func(ctx context.Context, message *PersistedEvent) error {
+				mu.Lock()
+				uniqueIds = append(uniqueIds, message.UniqueId)
+				mu.Unlock()
+				return next(ctx, message)
+			}
+		},
+	))
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Consume(ctx)
+
+	// Two instances share the locker, so each occurrence is enqueued once.
+	locker := &mockLocker{keys: make(map[string]struct{})}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		scheduler := NewScheduler(dispatcher, locker)
+		assert.NoError(t, scheduler.Add("foo", "@every 1s", events.Of(MockEvent{Value: "foo"})))
+		assert.Error(t, scheduler.Add("foo", "* * * * * *", events.Of(MockEvent{})))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.Run(ctx)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(uniqueIds) >= 2
+	}, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	seen := make(map[string]struct{})
+	for _, id := range uniqueIds {
+		assert.NotContains(t, seen, id)
+		seen[id] = struct{}{}
+	}
+}
+
+func TestScheduler_Add(t *testing.T) {
+	scheduler := NewScheduler(nil, nil)
+	assert.NoError(t, scheduler.Add("seconds", "*/5 * * * * *", events.Of(MockEvent{})))
+	assert.NoError(t, scheduler.Add("minutes", "0 3 * * *", events.Of(MockEvent{})))
+	assert.NoError(t, scheduler.Add("descriptor", "@hourly", events.Of(MockEvent{})))
+	assert.Error(t, scheduler.Add("invalid", "foo", events.Of(MockEvent{})))
+}
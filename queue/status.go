@@ -0,0 +1,251 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/DoNewsCode/core/otgorm"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The states of a job recorded in the StatusStore.
+const (
+	// StateQueued means the event is waiting in the queue, including being delayed.
+	StateQueued = "queued"
+	// StateRunning means the event is being handled.
+	StateRunning = "running"
+	// StateSucceeded means the event has been handled successfully.
+	StateSucceeded = "succeeded"
+	// StateFailed means the last attempt has failed, and the event will be retried.
+	StateFailed = "failed"
+	// StateAborted means all attempts have failed, and the event is moved to the failed channel.
+	StateAborted = "aborted"
+)
+
+// maxResultSize is the maximum size of the JSON result recorded by SetResult.
+const maxResultSize = 64 << 10
+
+// ErrNoStatus means the job status is not available, either because the dispatcher has no StatusStore, or the
+// context is not passed from the queue.
+var ErrNoStatus = errors.New("job status is not available")
+
+// Status is the state of a persisted event, keyed by its UniqueId.
+type Status struct {
+	UniqueId    string          `json:"uniqueId"`
+	Key         string          `json:"key"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	LastError   string          `json:"lastError,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// StatusStore saves the Status of persisted events. See UseStatusStore.
+type StatusStore interface {
+	// Set saves the status, replacing the previous one with the same UniqueId, unless the previous one is more
+	// recent by UpdatedAt. Statuses may be set out of order, for example the consumer of a message can set it
+	// running before the producer sets it queued.
+	Set(ctx context.Context, status Status) error
+	// Get returns the status by the UniqueId. If no such status exists, ErrNotFound is returned.
+	Get(ctx context.Context, uniqueId string) (Status, error)
+}
+
+type resultKey struct{}
+
+// SetResult records a small result of the persisted event being handled, such as the URL of an exported file. The
+// value is marshaled to JSON and saved along with the status once the event succeeds. It must not exceed 64KiB.
+//
+//  func (l ExportListener) Process(ctx context.Context, event contract.Event) error {
+//    url, err := l.export(ctx, event.Data().(ExportEvent))
+//    if err != nil {
+//      return err
+//    }
+//    return queue.SetResult(ctx, map[string]string{"url": url})
+//  }
+func SetResult(ctx context.Context, value interface{}) error {
+	result, ok := ctx.Value(resultKey{}).(*json.RawMessage)
+	if !ok {
+		return ErrNoStatus
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the result")
+	}
+	if len(data) > maxResultSize {
+		return fmt.Errorf("the result is %d bytes, larger than %d bytes", len(data), maxResultSize)
+	}
+	*result = data
+	return nil
+}
+
+// RedisStatusStore is a StatusStore backed by redis. Each status is saved as a hash, holding the JSON string and
+// the update time. It expires after TTL.
+type RedisStatusStore struct {
+	RedisClient redis.UniversalClient // RedisClient is used to communicate with redis
+	Prefix      string                // Prefix is prepended to the UniqueId to form the redis key
+	TTL         time.Duration         // TTL is how long the status is kept after the last update. By default, 24 hours.
+}
+
+// setStatusScript saves the status unless the saved one is more recent.
+// KEYS: status. ARGV: JSON, update time in microseconds, TTL in milliseconds.
+var setStatusScript = redis.NewScript(`
+local at = redis.call('HGET', KEYS[1], 'at')
+if at and tonumber(at) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'data', ARGV[1], 'at', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// Set implements StatusStore.
+func (r *RedisStatusStore) Set(ctx context.Context, status Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal status")
+	}
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	at := status.UpdatedAt.UnixNano() / int64(time.Microsecond)
+	if status.UpdatedAt.IsZero() {
+		at = 0
+	}
+	err = setStatusScript.Run(ctx, r.RedisClient, []string{r.key(status.UniqueId)}, data, at, ttl.Milliseconds()).Err()
+	return errors.Wrapf(err, "failed to save status %s", status.UniqueId)
+}
+
+// Get implements StatusStore.
+func (r *RedisStatusStore) Get(ctx context.Context, uniqueId string) (Status, error) {
+	var status Status
+	data, err := r.RedisClient.HGet(ctx, r.key(uniqueId), "data").Bytes()
+	if err == redis.Nil {
+		return status, ErrNotFound
+	}
+	if err != nil {
+		return status, errors.Wrapf(err, "failed to get status %s", uniqueId)
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, errors.Wrapf(err, "failed to unmarshal status %s", uniqueId)
+	}
+	return status, nil
+}
+
+func (r *RedisStatusStore) key(uniqueId string) string {
+	if r.Prefix == "" {
+		return uniqueId
+	}
+	return r.Prefix + ":" + uniqueId
+}
+
+// gormStatus is the table schema used by GormStatusStore.
+type gormStatus struct {
+	Queue       string `gorm:"size:191;primaryKey"`
+	UniqueId    string `gorm:"size:191;primaryKey"`
+	Event       string `gorm:"size:191"`
+	State       string `gorm:"size:16"`
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	Result      []byte
+	UpdatedAt   time.Time
+}
+
+// TableName implements gorm's schema.Tabler.
+func (gormStatus) TableName() string {
+	return "queue_statuses"
+}
+
+// GormStatusMigrations returns the database migrations needed for GormStatusStore.
+func GormStatusMigrations(connection string) []*otgorm.Migration {
+	return []*otgorm.Migration{
+		{
+			ID:         "202104200100",
+			Connection: connection,
+			Migrate: func(db *gorm.DB) error {
+				return db.AutoMigrate(&gormStatus{})
+			},
+			Rollback: func(db *gorm.DB) error {
+				return db.Migrator().DropTable(&gormStatus{})
+			},
+		},
+	}
+}
+
+// GormStatusStore is a StatusStore backed by relational databases. Statuses are saved in the queue_statuses table,
+// which can be created by GormStatusMigrations. Unlike RedisStatusStore, statuses never expire. Old rows should be
+// purged by the users.
+type GormStatusStore struct {
+	DB    *gorm.DB // DB is the database connection. The queue_statuses table must be migrated beforehand.
+	Queue string   // Queue is the name of the queue. Many queues can share the same table.
+}
+
+// Set implements StatusStore.
+func (g *GormStatusStore) Set(ctx context.Context, status Status) error {
+	db := g.DB.WithContext(ctx)
+	update := func() (int64, error) {
+		result := db.Model(&gormStatus{}).
+			Where("queue = ? AND unique_id = ? AND updated_at <= ?", g.Queue, status.UniqueId, status.UpdatedAt).
+			UpdateColumns(map[string]interface{}{
+				"event":        status.Key,
+				"state":        status.State,
+				"attempts":     status.Attempts,
+				"max_attempts": status.MaxAttempts,
+				"last_error":   status.LastError,
+				"result":       []byte(status.Result),
+				"updated_at":   status.UpdatedAt,
+			})
+		return result.RowsAffected, result.Error
+	}
+	// The row is updated if it is older, or inserted if missing. Should it be inserted by others in the meantime,
+	// it is updated once more.
+	if affected, err := update(); err != nil || affected > 0 {
+		return errors.Wrapf(err, "failed to save status %s", status.UniqueId)
+	}
+	row := gormStatus{
+		Queue:       g.Queue,
+		UniqueId:    status.UniqueId,
+		Event:       status.Key,
+		State:       status.State,
+		Attempts:    status.Attempts,
+		MaxAttempts: status.MaxAttempts,
+		LastError:   status.LastError,
+		Result:      status.Result,
+		UpdatedAt:   status.UpdatedAt,
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return errors.Wrapf(result.Error, "failed to save status %s", status.UniqueId)
+	}
+	_, err := update()
+	return errors.Wrapf(err, "failed to save status %s", status.UniqueId)
+}
+
+// Get implements StatusStore.
+func (g *GormStatusStore) Get(ctx context.Context, uniqueId string) (Status, error) {
+	var row gormStatus
+	err := g.DB.WithContext(ctx).Where("queue = ? AND unique_id = ?", g.Queue, uniqueId).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Status{}, ErrNotFound
+	}
+	if err != nil {
+		return Status{}, errors.Wrapf(err, "failed to get status %s", uniqueId)
+	}
+	return Status{
+		UniqueId:    row.UniqueId,
+		Key:         row.Event,
+		State:       row.State,
+		Attempts:    row.Attempts,
+		MaxAttempts: row.MaxAttempts,
+		LastError:   row.LastError,
+		Result:      row.Result,
+		UpdatedAt:   row.UpdatedAt,
+	}, nil
+}
@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DoNewsCode/core/contract"
+	"github.com/DoNewsCode/core/events"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setUpGormStatusStore(t *testing.T) *GormStatusStore {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	// Each connection to sqlite :memory: opens a new database.
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	for _, migration := range GormStatusMigrations("default") {
+		assert.NoError(t, migration.Migrate(db))
+	}
+	return &GormStatusStore{DB: db, Queue: "test"}
+}
+
+func TestGormStatusStore(t *testing.T) {
+	testStatusStore(t, setUpGormStatusStore(t))
+}
+
+func TestRedisStatusStore(t *testing.T) {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{os.Getenv("REDIS_ADDR")}})
+	defer client.Close()
+	client.Del(context.Background(), "{RedisStatusStore}:foo")
+	testStatusStore(t, &RedisStatusStore{RedisClient: client, Prefix: "{RedisStatusStore}"})
+}
+
+func testStatusStore(t *testing.T, store StatusStore) {
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "foo")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, store.Set(ctx, Status{UniqueId: "foo", State: StateQueued, MaxAttempts: 2}))
+	now := time.Now()
+	assert.NoError(t, store.Set(ctx, Status{UniqueId: "foo", State: StateSucceeded, Attempts: 1, MaxAttempts: 2, Result: []byte(`"bar"`), UpdatedAt: now}))
+	// older statuses are ignored
+	assert.NoError(t, store.Set(ctx, Status{UniqueId: "foo", State: StateRunning, Attempts: 1, MaxAttempts: 2, UpdatedAt: now.Add(-time.Second)}))
+	status, err := store.Get(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, StateSucceeded, status.State)
+	assert.Equal(t, 1, status.Attempts)
+	assert.JSONEq(t, `"bar"`, string(status.Result))
+}
+
+func TestDispatcher_status(t *testing.T) {
+	store := setUpGormStatusStore(t)
+	dispatcher := WithQueue(
+		&events.SyncDispatcher{},
+		NewInProcessDriverWithPopInterval(time.Millisecond),
+		UseParallelism(1),
+		UseStatusStore(store),
+	)
+	running := make(chan struct{})
+	proceed := make(chan error)
+	dispatcher.Subscribe(MockListener(func(ctx context.Context, event contract.Event) error {
+		running <- struct{}{}
+		if err := <-proceed; err != nil {
+			return err
+		}
+		return SetResult(ctx, map[string]string{"url": event.Data().(MockEvent).Value})
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "foo"}), UniqueId("foo"), MaxAttempts(2))))
+	assert.Equal(t, StateQueued, statusOf(t, dispatcher, "foo").State)
+
+	go dispatcher.Consume(ctx)
+	<-running
+	assert.Equal(t, StateRunning, statusOf(t, dispatcher, "foo").State)
+	proceed <- errors.New("some error")
+
+	<-running
+	status := statusOf(t, dispatcher, "foo")
+	assert.Equal(t, StateRunning, status.State)
+	assert.Equal(t, 2, status.Attempts)
+	proceed <- nil
+
+	assert.Eventually(t, func() bool {
+		return statusOf(t, dispatcher, "foo").State == StateSucceeded
+	}, time.Second, time.Millisecond)
+	status = statusOf(t, dispatcher, "foo")
+	assert.Equal(t, "", status.LastError)
+	assert.JSONEq(t, `{"url":"foo"}`, string(status.Result))
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{Value: "bar"}), UniqueId("bar"))))
+	<-running
+	proceed <- errors.New("some error")
+	assert.Eventually(t, func() bool {
+		return statusOf(t, dispatcher, "bar").State == StateAborted
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "some error", statusOf(t, dispatcher, "bar").LastError)
+
+	assert.ErrorIs(t, SetResult(context.Background(), "foo"), ErrNoStatus)
+	_, err := WithQueue(&events.SyncDispatcher{}, NewInProcessDriver()).Status(ctx, "foo")
+	assert.ErrorIs(t, err, ErrNoStatus)
+}
+
+// racingDriver updates the status as soon as the message is pushed, like a consumer faster than the dispatcher.
+type racingDriver struct {
+	Driver
+	store StatusStore
+}
+
+func (r racingDriver) Push(ctx context.Context, message *PersistedEvent, delay time.Duration) error {
+	if err := r.Driver.Push(ctx, message, delay); err != nil {
+		return err
+	}
+	return r.store.Set(ctx, Status{UniqueId: message.UniqueId, State: StateRunning, Attempts: 1, UpdatedAt: time.Now()})
+}
+
+func TestDispatcher_statusOfFastConsumer(t *testing.T) {
+	store := setUpGormStatusStore(t)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, racingDriver{NewInProcessDriver(), store}, UseStatusStore(store))
+
+	assert.NoError(t, dispatcher.Dispatch(context.Background(), Persist(events.Of(MockEvent{}), UniqueId("foo"))))
+	assert.Equal(t, StateRunning, statusOf(t, dispatcher, "foo").State)
+}
+
+func TestDispatcher_statusOfDuplicate(t *testing.T) {
+	ctx := context.Background()
+	store := setUpGormStatusStore(t)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, NewInProcessDriver(), UseStatusStore(store))
+
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}), UniqueId("foo"), Unique(DropDuplicate))))
+	assert.NoError(t, store.Set(ctx, Status{UniqueId: "foo", State: StateRunning, Attempts: 1, UpdatedAt: time.Now()}))
+	assert.NoError(t, dispatcher.Dispatch(ctx, Persist(events.Of(MockEvent{}), UniqueId("foo"), Unique(DropDuplicate))))
+	status := statusOf(t, dispatcher, "foo")
+	assert.Equal(t, StateRunning, status.State)
+	assert.Equal(t, 1, status.Attempts)
+}
+
+func TestDispatcher_statusOfFailedPush(t *testing.T) {
+	store := setUpGormStatusStore(t)
+	dispatcher := WithQueue(&events.SyncDispatcher{}, failingDriver{NewInProcessDriver()}, UseStatusStore(store))
+
+	assert.Error(t, dispatcher.Dispatch(context.Background(), Persist(events.Of(MockEvent{}), UniqueId("foo"))))
+	_, err := dispatcher.Status(context.Background(), "foo")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func statusOf(t *testing.T, dispatcher *QueueableDispatcher, uniqueId string) Status {
+	status, err := dispatcher.Status(context.Background(), uniqueId)
+	assert.NoError(t, err)
+	return status
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/DoNewsCode/core/dtx"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// spanCarrier is an opentracing.TextMap carrier backed by PersistedEvent.SpanContext. A slice of keys and values is
+// used instead of a map, so that the encoded message stays deterministic.
+type spanCarrier struct {
+	pairs *[]string
+}
+
+// Set implements opentracing.TextMapWriter.
+func (c spanCarrier) Set(key, val string) {
+	*c.pairs = append(*c.pairs, key, val)
+}
+
+// ForeachKey implements opentracing.TextMapReader.
+func (c spanCarrier) ForeachKey(handler func(key, val string) error) error {
+	for i := 0; i+1 < len(*c.pairs); i += 2 {
+		if err := handler((*c.pairs)[i], (*c.pairs)[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inject embeds the span context and the correlation id found in the producer's context into the message.
+func inject(ctx context.Context, msg *PersistedEvent) {
+	if cid, ok := ctx.Value(dtx.CorrelationID).(string); ok {
+		msg.CorrelationID = cid
+	}
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	msg.SpanContext = nil
+	_ = span.Tracer().Inject(span.Context(), opentracing.TextMap, spanCarrier{&msg.SpanContext})
+}
+
+// extract starts a consumer span as a child of the producer's span, and restores the correlation id. The span must
+// be finished by the caller.
+func extract(ctx context.Context, tracer opentracing.Tracer, msg *PersistedEvent) (opentracing.Span, context.Context) {
+	if msg.CorrelationID != "" {
+		ctx = context.WithValue(ctx, dtx.CorrelationID, msg.CorrelationID)
+	}
+	spanContext, _ := tracer.Extract(opentracing.TextMap, spanCarrier{&msg.SpanContext})
+	span := tracer.StartSpan("queue:"+msg.Key, ext.RPCServerOption(spanContext))
+	ext.SpanKind.Set(span, ext.SpanKindConsumerEnum)
+	ext.Component.Set(span, "queue")
+	span.SetTag("attempts", msg.Attempts)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}